@@ -0,0 +1,112 @@
+package zrpc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Balancer 負載平衡策略，從可用的端點中挑選一個
+type Balancer interface {
+	Pick(endpoints []*Endpoint, data *Input) *Endpoint
+}
+
+// NewRoundRobinBalancer 依序輪流挑選端點
+func NewRoundRobinBalancer() Balancer {
+	return &roundRobinBalancer{}
+}
+
+// NewRandomBalancer 隨機挑選端點
+func NewRandomBalancer() Balancer {
+	return randomBalancer{}
+}
+
+// NewLeastPendingBalancer 挑選處理中請求最少的端點
+func NewLeastPendingBalancer() Balancer {
+	return leastPendingBalancer{}
+}
+
+// NewHashBalancer 依請求參數中的欄位做一致性雜湊，相同的值會落在相同的端點
+func NewHashBalancer(field string) Balancer {
+	return hashBalancer{field: field}
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+func (b *roundRobinBalancer) Pick(endpoints []*Endpoint, data *Input) *Endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&b.next, 1)
+	return endpoints[(n-1)%uint64(len(endpoints))]
+}
+
+type randomBalancer struct{}
+
+func (randomBalancer) Pick(endpoints []*Endpoint, data *Input) *Endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	return endpoints[rand.Intn(len(endpoints))]
+}
+
+type leastPendingBalancer struct{}
+
+func (leastPendingBalancer) Pick(endpoints []*Endpoint, data *Input) *Endpoint {
+	var picked *Endpoint
+	for _, ep := range endpoints {
+		if picked == nil || ep.Pending() < picked.Pending() {
+			picked = ep
+		}
+	}
+	return picked
+}
+
+type hashBalancer struct {
+	field string
+}
+
+// Pick 以最高隨機權重(rendezvous hashing)挑選，端點增減時只影響少部分的鍵
+func (b hashBalancer) Pick(endpoints []*Endpoint, data *Input) *Endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	key, ok := b.key(data)
+	if !ok {
+		return endpoints[rand.Intn(len(endpoints))]
+	}
+
+	var (
+		picked *Endpoint
+		max    uint64
+	)
+	for _, ep := range endpoints {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(ep.RPCAddress))
+		if score := h.Sum64(); picked == nil || score > max {
+			picked, max = ep, score
+		}
+	}
+	return picked
+}
+
+// key 取出參數中的雜湊欄位
+func (b hashBalancer) key(data *Input) (string, bool) {
+	if data == nil {
+		return "", false
+	}
+	params, ok := data.Params.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	value, ok := params[b.field]
+	if !ok || value == nil {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
@@ -0,0 +1,221 @@
+package zrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// BreakerState 斷路器狀態
+type BreakerState string
+
+// 斷路器狀態
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerConfig 斷路器設定
+type BreakerConfig struct {
+	Window           time.Duration // 統計失敗率的時間區間
+	MinRequests      int           // 區間內至少要有幾次請求才會斷開
+	FailureRate      float64       // 失敗率達到此值(0~1)即斷開
+	CoolDown         time.Duration // 斷開後經過多久進入半開
+	HalfOpenRequests int           // 半開時允許試探的請求數，全部成功才關閉
+}
+
+// DefaultBreakerConfig 預設的斷路器設定
+var DefaultBreakerConfig = BreakerConfig{
+	Window:           10 * time.Second,
+	MinRequests:      10,
+	FailureRate:      0.5,
+	CoolDown:         5 * time.Second,
+	HalfOpenRequests: 1,
+}
+
+// Breaker 斷路器
+type Breaker struct {
+	mx          sync.Mutex
+	config      BreakerConfig
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     int
+	probed      int
+}
+
+// NewBreaker 建立斷路器，未設定的欄位使用預設值
+func NewBreaker(config BreakerConfig) *Breaker {
+	if config.Window <= 0 {
+		config.Window = DefaultBreakerConfig.Window
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DefaultBreakerConfig.MinRequests
+	}
+	if config.FailureRate <= 0 || config.FailureRate > 1 {
+		config.FailureRate = DefaultBreakerConfig.FailureRate
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = DefaultBreakerConfig.CoolDown
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = DefaultBreakerConfig.HalfOpenRequests
+	}
+	return &Breaker{
+		config:      config,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// State 目前狀態
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.refresh(time.Now())
+	return b.state
+}
+
+// Ready 是否可能放行請求，不佔用半開時的試探名額
+func (b *Breaker) Ready() bool {
+	if b == nil {
+		return true
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probing < b.config.HalfOpenRequests-b.probed
+	}
+	return true
+}
+
+// Allow 是否放行請求，放行後必須呼叫Done回報結果
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.refresh(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probing >= b.config.HalfOpenRequests-b.probed {
+			return false
+		}
+		b.probing++
+	}
+	return true
+}
+
+// Done 回報請求結果
+func (b *Breaker) Done(success bool) {
+	if b == nil {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	now := time.Now()
+	b.refresh(now)
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if b.probing > 0 {
+			b.probing--
+		}
+		if !success {
+			b.open(now)
+			return
+		}
+		b.probed++
+		if b.probed >= b.config.HalfOpenRequests {
+			b.close(now)
+		}
+	case BreakerClosed:
+		b.requests++
+		if !success {
+			b.failures++
+		}
+		if b.requests >= b.config.MinRequests &&
+			float64(b.failures)/float64(b.requests) >= b.config.FailureRate {
+			b.open(now)
+		}
+	}
+}
+
+// release 歸還Allow放行的名額，請求未送出時使用，不計入結果
+func (b *Breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.state == BreakerHalfOpen && b.probing > 0 {
+		b.probing--
+	}
+}
+
+// MarshalJSON 輸出斷路器狀態
+func (b *Breaker) MarshalJSON() ([]byte, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.refresh(time.Now())
+	return json.Marshal(map[string]interface{}{
+		"state":    b.state,
+		"requests": b.requests,
+		"failures": b.failures,
+	})
+}
+
+// refresh 依時間切換統計區間與半開狀態，呼叫前需持有鎖
+func (b *Breaker) refresh(now time.Time) {
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) >= b.config.CoolDown {
+			b.state = BreakerHalfOpen
+			b.probing = 0
+			b.probed = 0
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	}
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+}
+
+func (b *Breaker) close(now time.Time) {
+	b.state = BreakerClosed
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
+
+// isFailure 是否為上游失敗，服務自己回傳的錯誤與呼叫端取消不計入
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var serverErr rpc.ServerError
+	return !errors.As(err, &serverErr)
+}
@@ -0,0 +1,61 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+func TestCanceledIsNotFailure(t *testing.T) {
+	err := fmt.Errorf("call arith.Sum: %w", context.Canceled)
+	if isFailure(err) {
+		t.Fatal("canceled call counted as failure")
+	}
+	if code := CodeOf(err); code != CodeCanceled {
+		t.Fatalf("code %s, want %s", code, CodeCanceled)
+	}
+	if status := StatusOf(err); status != 499 {
+		t.Fatalf("status %d, want 499", status)
+	}
+	policy := RetryPolicy{Methods: []string{"*"}}.normalize()
+	if policy.retryable(err) {
+		t.Fatal("canceled call is retryable")
+	}
+
+	if !isFailure(errors.New("connection refused")) {
+		t.Fatal("connection error not counted as failure")
+	}
+	if isFailure(rpc.ServerError("boom")) {
+		t.Fatal("service error counted as failure")
+	}
+}
+
+func TestCanceledForwardKeepsBreaker(t *testing.T) {
+	backend := startTestServer(t)
+	proxy := NewProxy().AddService("Arith", backend.JSONRPCNet.Addr().String(), "",
+		WithRPCName("arith"), WithBreaker(BreakerConfig{MinRequests: 1, FailureRate: 0.1}))
+	proxy.PrefixPath = "/"
+	defer proxy.client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	body := `{"jsonrpc":"2.0","id":1,"service":"Arith","method":"Sum","params":{"A":1,"B":2}}`
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body)).WithContext(ctx)
+		proxy.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	service, _ := proxy.Services.Get("Arith")
+	if state := service.Breaker.State(); state != BreakerClosed {
+		t.Fatalf("breaker %s after canceled calls", state)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"result":3`) {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,369 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// 每個位址預設的連線數
+const defaultPoolSize = 4
+
+// Client 客戶端
+type Client struct {
+	Address      string
+	kind         string
+	poolSize     int
+	retry        *RetryPolicy
+	interceptors []ClientInterceptor
+	mx           *sync.Mutex
+	pools        map[string]*clientPool
+}
+
+// clientPool 單一位址的連線池
+type clientPool struct {
+	mx      sync.Mutex
+	address string
+	conns   []*rpc.Client
+	next    int
+	dialing int       // 建立中的連線數，已預留在連線池的名額內
+	first   *poolDial // 連線池為空時建立中的連線，其他呼叫等待其結果
+	closed  bool      // 連線池已關閉，之後建立的連線直接關閉
+}
+
+// poolDial 連線池為空時的一次連線建立
+type poolDial struct {
+	done     chan struct{}
+	err      error
+	canceled bool // 建立者的ctx已結束，等待者需自行重試
+}
+
+// NewClient 建立一個客戶端
+func NewClient(address string) *Client {
+	client := &Client{
+		Address:  address,
+		kind:     "jsonrpc",
+		poolSize: defaultPoolSize,
+		mx:       new(sync.Mutex),
+		pools:    map[string]*clientPool{},
+	}
+
+	if os.Getenv("ZRPC_CLIENT") == "rpc" {
+		client.kind = "rpc"
+	}
+
+	// 檢查連線池大小環境變數
+	if ps := os.Getenv("ZRPC_CLIENT_POOL_SIZE"); ps != "" {
+		if size, err := strconv.Atoi(ps); err == nil {
+			client.SetPoolSize(size)
+		}
+	}
+	return client
+}
+
+// SetClient 設定客戶端協定
+func (client *Client) SetClient(s string) *Client {
+	if s == "rpc" || s == "jsonrpc" {
+		client.kind = s
+	} else {
+		panic("client is wrong")
+	}
+	return client
+}
+
+// SetAddress 設定預設的連線網址
+func (client *Client) SetAddress(addr string) *Client {
+	client.Address = addr
+	return client
+}
+
+// SetPoolSize 設定每個位址的連線數
+func (client *Client) SetPoolSize(size int) *Client {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+	client.poolSize = size
+	return client
+}
+
+// SetRetryPolicy 設定重試策略，只有policy.Methods中的冪等方法會重試
+func (client *Client) SetRetryPolicy(policy RetryPolicy) *Client {
+	client.retry = policy.normalize()
+	return client
+}
+
+// Call 呼叫服務，並等待結果
+func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return client.call(context.Background(), client.Address, serviceMethod, args, reply)
+}
+
+// CallContext 呼叫服務，ctx取消或逾時即返回，期限會一併傳給伺服端
+func (client *Client) CallContext(ctx context.Context, service, method string, params interface{}, reply interface{}) error {
+	return client.call(ctx, client.Address, joinServiceMethod(service, method), params, reply)
+}
+
+// Go 非同步呼叫服務，完成時將結果送至done
+func (client *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	return client.goCall(client.Address, serviceMethod, args, reply, done)
+}
+
+// Close 關閉所有連線
+func (client *Client) Close() error {
+	client.mx.Lock()
+	pools := client.pools
+	client.pools = map[string]*clientPool{}
+	client.mx.Unlock()
+
+	var err error
+	for _, pool := range pools {
+		if e := pool.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// closeAddress 關閉指定位址的所有連線
+func (client *Client) closeAddress(address string) error {
+	client.mx.Lock()
+	pool, ok := client.pools[address]
+	delete(client.pools, address)
+	client.mx.Unlock()
+	if !ok {
+		return nil
+	}
+	return pool.close()
+}
+
+// call 對指定位址呼叫服務，冪等方法依重試策略重試
+// 服務回傳的ZRPC錯誤會還原為 *ErrorDetail，可用errors.As取得
+func (client *Client) call(ctx context.Context, address, serviceMethod string, args interface{}, reply interface{}) error {
+	return client.callWith(ctx, &CallInfo{
+		ServiceMethod: serviceMethod,
+		Transport:     client.kind,
+		RemoteAddr:    address,
+	}, args, reply)
+}
+
+// callWith 經過攔截器後依info呼叫服務
+func (client *Client) callWith(ctx context.Context, info *CallInfo, args interface{}, reply interface{}) error {
+	return client.intercept(info, func(ctx context.Context, args interface{}, reply interface{}) error {
+		return retry(ctx, client.retry, info.ServiceMethod, func() error {
+			return wireError(client.callOnce(ctx, info, args, reply))
+		})
+	})(ctx, args, reply)
+}
+
+// callOnce 依info對指定位址呼叫服務，連線中斷時自動重新連線
+func (client *Client) callOnce(ctx context.Context, info *CallInfo, args interface{}, reply interface{}) error {
+	pool := client.pool(info.RemoteAddr)
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		conn, err := pool.get(ctx, client.kind, client.poolSize)
+		if err != nil {
+			return err
+		}
+
+		err = invoke(ctx, conn, info, args, reply)
+		if err == nil {
+			return nil
+		}
+		var serverErr rpc.ServerError
+		if errors.As(err, &serverErr) || err == ctx.Err() {
+			return err
+		}
+
+		// 連線已損壞，丟棄後視情況重試
+		pool.discard(conn)
+		if attempt > 0 || !isReconnectable(err) {
+			return err
+		}
+	}
+}
+
+// goCall 對指定位址非同步呼叫服務
+func (client *Client) goCall(address, serviceMethod string, args interface{}, reply interface{}, done chan *rpc.Call) *rpc.Call {
+	if done == nil {
+		done = make(chan *rpc.Call, 1)
+	} else if cap(done) == 0 {
+		panic("zrpc: done channel is unbuffered")
+	}
+	call := &rpc.Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+	}
+	go func() {
+		call.Error = client.call(context.Background(), address, serviceMethod, args, reply)
+		call.Done <- call
+	}()
+	return call
+}
+
+// pool 取得位址對應的連線池
+func (client *Client) pool(address string) *clientPool {
+	client.mx.Lock()
+	defer client.mx.Unlock()
+	pool, ok := client.pools[address]
+	if !ok {
+		pool = &clientPool{address: address}
+		client.pools[address] = pool
+	}
+	return pool
+}
+
+// get 以輪詢方式取出一條連線，不足時建立新連線
+// 建立連線時不持有鎖，先預留名額，連上後再放入連線池
+// 連線池為空時只建立一條連線，其他呼叫等待結果，避免後端恢復時同時湧入大量連線
+func (pool *clientPool) get(ctx context.Context, kind string, size int) (*rpc.Client, error) {
+	pool.mx.Lock()
+	defer pool.mx.Unlock()
+
+	for {
+		if pool.closed {
+			return nil, rpc.ErrShutdown
+		}
+		if len(pool.conns) > 0 && len(pool.conns)+pool.dialing >= size {
+			break
+		}
+		if len(pool.conns) == 0 && pool.first != nil {
+			d := pool.first
+			pool.mx.Unlock()
+			select {
+			case <-d.done:
+			case <-ctx.Done():
+				pool.mx.Lock()
+				return nil, ctx.Err()
+			}
+			pool.mx.Lock()
+			if d.err != nil && !d.canceled {
+				return nil, d.err
+			}
+			continue
+		}
+
+		pool.dialing++
+		var d *poolDial
+		if len(pool.conns) == 0 {
+			d = &poolDial{done: make(chan struct{})}
+			pool.first = d
+		}
+		pool.mx.Unlock()
+		conn, err := dialClient(ctx, kind, pool.address)
+		pool.mx.Lock()
+		pool.dialing--
+		if d != nil {
+			d.err, d.canceled = err, ctx.Err() != nil
+			pool.first = nil
+			close(d.done)
+		}
+
+		switch {
+		case err == nil && pool.closed:
+			conn.Close()
+			return nil, rpc.ErrShutdown
+		case err == nil && len(pool.conns) >= size:
+			// 建立期間其他呼叫已補滿連線池
+			conn.Close()
+		case err == nil:
+			pool.conns = append(pool.conns, conn)
+			return conn, nil
+		case len(pool.conns) == 0:
+			return nil, err
+		}
+		break
+	}
+
+	pool.next = (pool.next + 1) % len(pool.conns)
+	return pool.conns[pool.next], nil
+}
+
+// discard 丟棄損壞的連線
+func (pool *clientPool) discard(conn *rpc.Client) {
+	pool.mx.Lock()
+	defer pool.mx.Unlock()
+	for i, c := range pool.conns {
+		if c == conn {
+			pool.conns = append(pool.conns[:i], pool.conns[i+1:]...)
+			break
+		}
+	}
+	conn.Close()
+}
+
+// close 關閉連線池內所有連線
+func (pool *clientPool) close() error {
+	pool.mx.Lock()
+	defer pool.mx.Unlock()
+	var err error
+	for _, conn := range pool.conns {
+		if e := conn.Close(); e != nil && e != rpc.ErrShutdown && err == nil {
+			err = e
+		}
+	}
+	pool.conns = nil
+	pool.closed = true
+	return err
+}
+
+// dialClient 依協定建立連線
+func dialClient(ctx context.Context, kind, address string) (*rpc.Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "rpc" {
+		return rpc.NewClientWithCodec(newGobClientCodec(conn)), nil
+	}
+	return rpc.NewClientWithCodec(newJSONClientCodec(conn)), nil
+}
+
+// invoke 在連線上呼叫服務，並等待結果或ctx結束
+func invoke(ctx context.Context, conn *rpc.Client, info *CallInfo, args interface{}, reply interface{}) error {
+	deadline, _ := ctx.Deadline()
+	args = &callArgs{args: args, deadline: deadline, metadata: info.Metadata}
+	if ctx.Done() == nil {
+		return conn.Call(info.ServiceMethod, args, reply)
+	}
+
+	// 先寫入暫存的回應，避免放棄等待後仍寫入呼叫端的reply
+	replyv := reflect.ValueOf(reply)
+	result := reply
+	if replyv.Kind() == reflect.Ptr && !replyv.IsNil() {
+		result = reflect.New(replyv.Type().Elem()).Interface()
+	}
+
+	call := conn.Go(info.ServiceMethod, args, result, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == nil && result != reply {
+			replyv.Elem().Set(reflect.ValueOf(result).Elem())
+		}
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// joinServiceMethod 組合成net/rpc的Service.Method名稱
+func joinServiceMethod(service, method string) string {
+	if service == "" {
+		return method
+	}
+	return service + "." + method
+}
+
+// isReconnectable 請求尚未送達伺服端，可以安全地換條連線重送
+func isReconnectable(err error) bool {
+	return err == rpc.ErrShutdown || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,107 @@
+package zrpc
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingListener 接受連線並計數，不回應任何請求
+func countingListener(t *testing.T) (net.Listener, *int64) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := new(int64)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(accepted, 1)
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		l.Close()
+	})
+	return l, accepted
+}
+
+func TestClientPoolConcurrentDial(t *testing.T) {
+	l, accepted := countingListener(t)
+	pool := &clientPool{address: l.Addr().String()}
+	defer pool.close()
+
+	const callers, size = 50, 2
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.get(context.Background(), "jsonrpc", size); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt64(accepted); n > size {
+		t.Fatalf("dialed %d connections, pool size is %d", n, size)
+	}
+	if len(pool.conns) == 0 || len(pool.conns) > size {
+		t.Fatalf("pool has %d connections", len(pool.conns))
+	}
+}
+
+func TestClientPoolDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	pool := &clientPool{address: address}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.get(context.Background(), "jsonrpc", 4); err == nil {
+				t.Error("expected dial error")
+			}
+		}()
+	}
+	wg.Wait()
+	if pool.first != nil || pool.dialing != 0 {
+		t.Fatalf("pool left dialing state: %d", pool.dialing)
+	}
+}
+
+func TestClientPoolCanceledDialer(t *testing.T) {
+	l, _ := countingListener(t)
+	pool := &clientPool{address: l.Addr().String()}
+	defer pool.close()
+
+	// 建立時ctx已結束的失敗不影響之後的呼叫
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.get(ctx, "jsonrpc", 1); err == nil {
+		t.Fatal("expected canceled dial to fail")
+	}
+	if _, err := pool.get(context.Background(), "jsonrpc", 1); err != nil {
+		t.Fatal(err)
+	}
+}
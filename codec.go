@@ -0,0 +1,501 @@
+package zrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// 呼叫端已放棄的請求，伺服端不再執行
+var errDeadlineExceeded = DeadlineExceeded("Deadline Exceeded", nil)
+
+var errMissingParams = errors.New("jsonrpc: request body missing params")
+
+var null = json.RawMessage([]byte("null"))
+
+// callArgs 附帶期限與metadata的呼叫參數，編碼時拆開並將剩餘時間與metadata寫入請求
+type callArgs struct {
+	args     interface{}
+	deadline time.Time
+	metadata map[string]string
+}
+
+// unwrapArgs 取出原始參數、剩餘時間與metadata
+func unwrapArgs(body interface{}) (interface{}, time.Duration, map[string]string) {
+	args, ok := body.(*callArgs)
+	if !ok {
+		return body, 0, nil
+	}
+	if args.deadline.IsZero() {
+		return args.args, 0, args.metadata
+	}
+	timeout := time.Until(args.deadline)
+	if timeout <= 0 {
+		timeout = time.Nanosecond
+	}
+	return args.args, timeout, args.metadata
+}
+
+// deadlineAfter 依收到的剩餘時間換算期限
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// expired 期限是否已過
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
+
+// ========== JSON-RPC ==========
+
+// jsonClientRequest JSON-RPC請求，timeout為剩餘毫秒數
+type jsonClientRequest struct {
+	Method   string            `json:"method"`
+	Params   [1]interface{}    `json:"params"`
+	ID       uint64            `json:"id"`
+	Timeout  int64             `json:"timeout,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// jsonClientResponse JSON-RPC回應
+type jsonClientResponse struct {
+	ID     uint64           `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+// jsonClientCodec 與net/rpc/jsonrpc相容的客戶端編碼器
+type jsonClientCodec struct {
+	dec     *json.Decoder
+	enc     *json.Encoder
+	c       io.Closer
+	req     jsonClientRequest
+	resp    jsonClientResponse
+	mutex   sync.Mutex
+	pending map[uint64]string
+}
+
+func newJSONClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &jsonClientCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]string),
+	}
+}
+
+func (c *jsonClientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+
+	param, timeout, metadata := unwrapArgs(param)
+	c.req.Method = r.ServiceMethod
+	c.req.Params[0] = param
+	c.req.ID = r.Seq
+	c.req.Metadata = metadata
+	c.req.Timeout = 0
+	if timeout > 0 {
+		c.req.Timeout = int64((timeout + time.Millisecond - 1) / time.Millisecond)
+	}
+	return c.enc.Encode(&c.req)
+}
+
+func (c *jsonClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp.ID = 0
+	c.resp.Result = nil
+	c.resp.Error = nil
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	r.ServiceMethod = c.pending[c.resp.ID]
+	delete(c.pending, c.resp.ID)
+	c.mutex.Unlock()
+
+	r.Error = ""
+	r.Seq = c.resp.ID
+	if c.resp.Error != nil || c.resp.Result == nil {
+		x, ok := c.resp.Error.(string)
+		if !ok {
+			return fmt.Errorf("invalid error %v", c.resp.Error)
+		}
+		if x == "" {
+			x = "unspecified error"
+		}
+		r.Error = x
+	}
+	return nil
+}
+
+func (c *jsonClientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *jsonClientCodec) Close() error {
+	return c.c.Close()
+}
+
+// jsonServerRequest JSON-RPC請求
+type jsonServerRequest struct {
+	Method   string            `json:"method"`
+	Params   *json.RawMessage  `json:"params"`
+	ID       *json.RawMessage  `json:"id"`
+	Timeout  int64             `json:"timeout"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// jsonServerResponse JSON-RPC回應
+type jsonServerResponse struct {
+	ID     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+// jsonServerCodec 與net/rpc/jsonrpc相容的伺服端編碼器，會拒絕已逾時的請求
+type jsonServerCodec struct {
+	dec      *json.Decoder
+	enc      *json.Encoder
+	c        io.Closer
+	req      jsonServerRequest
+	deadline time.Time
+	mutex    sync.Mutex
+	seq      uint64
+	pending  map[uint64]*json.RawMessage
+}
+
+func newJSONServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &jsonServerCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]*json.RawMessage),
+	}
+}
+
+func (c *jsonServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req = jsonServerRequest{}
+	if err := c.dec.Decode(&c.req); err != nil {
+		return err
+	}
+	r.ServiceMethod = c.req.Method
+	c.deadline = deadlineAfter(time.Duration(c.req.Timeout) * time.Millisecond)
+
+	c.mutex.Lock()
+	c.seq++
+	c.pending[c.seq] = c.req.ID
+	c.req.ID = nil
+	r.Seq = c.seq
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *jsonServerCodec) ReadRequestBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	if expired(c.deadline) {
+		return errDeadlineExceeded
+	}
+	if c.req.Params == nil {
+		return errMissingParams
+	}
+	var params [1]interface{}
+	params[0] = x
+	return json.Unmarshal(*c.req.Params, &params)
+}
+
+func (c *jsonServerCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mutex.Lock()
+	b, ok := c.pending[r.Seq]
+	if !ok {
+		c.mutex.Unlock()
+		return errors.New("invalid sequence number in response")
+	}
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+
+	if b == nil {
+		b = &null
+	}
+	resp := jsonServerResponse{ID: b}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		resp.Error = r.Error
+	}
+	return c.enc.Encode(resp)
+}
+
+// requestDeadline 目前請求的期限
+func (c *jsonServerCodec) requestDeadline() time.Time {
+	return c.deadline
+}
+
+// requestMetadata 目前請求附帶的metadata
+func (c *jsonServerCodec) requestMetadata() map[string]string {
+	return c.req.Metadata
+}
+
+func (c *jsonServerCodec) Close() error {
+	return c.c.Close()
+}
+
+// ========== RPC (gob) ==========
+
+// gobRequest 與rpc.Request相容的請求標頭，額外帶上剩餘時間與metadata
+type gobRequest struct {
+	ServiceMethod string
+	Seq           uint64
+	Timeout       time.Duration
+	Metadata      map[string]string
+}
+
+// gobClientCodec 與net/rpc相容的gob客戶端編碼器
+type gobClientCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func newGobClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &gobClientCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+	}
+}
+
+func (c *gobClientCodec) WriteRequest(r *rpc.Request, body interface{}) (err error) {
+	body, timeout, metadata := unwrapArgs(body)
+	req := gobRequest{
+		ServiceMethod: r.ServiceMethod,
+		Seq:           r.Seq,
+		Timeout:       timeout,
+		Metadata:      metadata,
+	}
+	if err = c.enc.Encode(&req); err != nil {
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+// gobServerCodec 與net/rpc相容的gob伺服端編碼器，會拒絕已逾時的請求
+type gobServerCodec struct {
+	rwc      io.ReadWriteCloser
+	dec      *gob.Decoder
+	enc      *gob.Encoder
+	encBuf   *bufio.Writer
+	deadline time.Time
+	metadata map[string]string
+	closed   bool
+}
+
+func newGobServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	encBuf := bufio.NewWriter(conn)
+	return &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+	}
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	var req gobRequest
+	if err := c.dec.Decode(&req); err != nil {
+		return err
+	}
+	r.ServiceMethod = req.ServiceMethod
+	r.Seq = req.Seq
+	c.deadline = deadlineAfter(req.Timeout)
+	c.metadata = req.Metadata
+	return nil
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	if body == nil || !expired(c.deadline) {
+		return c.dec.Decode(body)
+	}
+
+	// 讀掉參數，維持資料流同步
+	var discard interface{}
+	if err := c.dec.Decode(discard); err != nil {
+		return err
+	}
+	return errDeadlineExceeded
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("[ZRPC] gob error encoding response:", err)
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("[ZRPC] gob error encoding body:", err)
+			c.Close()
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+// requestDeadline 目前請求的期限
+func (c *gobServerCodec) requestDeadline() time.Time {
+	return c.deadline
+}
+
+// requestMetadata 目前請求附帶的metadata
+func (c *gobServerCodec) requestMetadata() map[string]string {
+	return c.metadata
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// ========== Timeout ==========
+
+// timeoutConn 依請求的讀取狀態切換期限的連線
+//
+// 沒有處理中的請求時才套用閒置逾時，請求的第一個位元組到達後改用讀取逾時。
+type timeoutConn struct {
+	net.Conn
+	mx      sync.Mutex
+	idle    time.Duration
+	read    time.Duration
+	waiting bool
+	reading bool
+	pending int
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	// JSON請求之間的換行不算是新請求
+	if n > 0 && len(bytes.TrimSpace(p[:n])) > 0 {
+		c.mx.Lock()
+		if c.waiting && !c.reading {
+			c.reading = true
+			c.Conn.SetReadDeadline(deadlineAfter(c.read))
+		}
+		c.mx.Unlock()
+	}
+	return n, err
+}
+
+// waitRequest 開始等待下一個請求
+func (c *timeoutConn) waitRequest() {
+	c.mx.Lock()
+	c.waiting = true
+	c.reading = false
+	c.setIdleDeadline()
+	c.mx.Unlock()
+}
+
+// received 已讀完一個請求的標頭
+func (c *timeoutConn) received() {
+	c.mx.Lock()
+	c.waiting = false
+	c.pending++
+	c.mx.Unlock()
+}
+
+// responded 送出回應後重新計算閒置時間
+func (c *timeoutConn) responded() {
+	c.mx.Lock()
+	c.pending--
+	if c.waiting && !c.reading {
+		c.setIdleDeadline()
+	}
+	c.mx.Unlock()
+}
+
+// setIdleDeadline 依處理中的請求數設定閒置期限，呼叫前需持有鎖
+func (c *timeoutConn) setIdleDeadline() {
+	if c.pending > 0 {
+		c.Conn.SetReadDeadline(time.Time{})
+		return
+	}
+	c.Conn.SetReadDeadline(deadlineAfter(c.idle))
+}
+
+// timeoutServerCodec 每則訊息重新設定連線期限的編碼器
+type timeoutServerCodec struct {
+	rpc.ServerCodec
+	conn  *timeoutConn
+	write time.Duration
+}
+
+func (c *timeoutServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.conn.waitRequest()
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+	c.conn.received()
+	return nil
+}
+
+// requestDeadline 目前請求的期限
+func (c *timeoutServerCodec) requestDeadline() time.Time {
+	if rc, ok := c.ServerCodec.(requestCodec); ok {
+		return rc.requestDeadline()
+	}
+	return time.Time{}
+}
+
+// requestMetadata 目前請求附帶的metadata
+func (c *timeoutServerCodec) requestMetadata() map[string]string {
+	if rc, ok := c.ServerCodec.(requestCodec); ok {
+		return rc.requestMetadata()
+	}
+	return nil
+}
+
+func (c *timeoutServerCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.conn.SetWriteDeadline(deadlineAfter(c.write))
+	err := c.ServerCodec.WriteResponse(r, x)
+	c.conn.responded()
+	return err
+}
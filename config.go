@@ -0,0 +1,354 @@
+package zrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Duration 設定檔中的時間長度，可為時間字串(如 "1.5s")或秒數
+type Duration time.Duration
+
+// UnmarshalJSON 解析時間字串或秒數
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		t, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(t)
+		return nil
+	}
+
+	var second float64
+	if err := json.Unmarshal(b, &second); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	*d = Duration(second * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON 輸出時間字串
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ProxyConfig 代理伺服的設定檔
+type ProxyConfig struct {
+	HTTPAddress  string          `json:"http_address,omitempty"`
+	PrefixPath   string          `json:"prefix_path,omitempty"`
+	UI           *bool           `json:"ui,omitempty"`
+	Debug        *bool           `json:"debug,omitempty"`
+	Legacy       *bool           `json:"legacy,omitempty"`
+	AlwaysOK     *bool           `json:"always_ok,omitempty"`
+	BatchLimit   int             `json:"batch_limit,omitempty"`
+	ReadTimeout  Duration        `json:"read_timeout,omitempty"`
+	WriteTimeout Duration        `json:"write_timeout,omitempty"`
+	HealthCheck  *HealthConfig   `json:"health_check,omitempty"`
+	Services     []ServiceConfig `json:"services"`
+}
+
+// HealthConfig 健康檢查設定
+type HealthConfig struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout,omitempty"`
+}
+
+// ServiceConfig 服務設定，單一位址可直接填rpc_address與http_address
+type ServiceConfig struct {
+	Name        string           `json:"name"`
+	RPCName     string           `json:"rpc_name,omitempty"` // 後端註冊的服務名稱，預設同name
+	RPCAddress  string           `json:"rpc_address,omitempty"`
+	HTTPAddress string           `json:"http_address,omitempty"`
+	Endpoints   []EndpointConfig `json:"endpoints,omitempty"`
+	DNS         string           `json:"dns,omitempty"`      // "host:port" (A/AAAA) 或 "_service._proto.name" (SRV)
+	Balancer    string           `json:"balancer,omitempty"` // round_robin、random、least_pending、hash
+	HashField   string           `json:"hash_field,omitempty"`
+	Timeout     Duration         `json:"timeout,omitempty"`
+	Breaker     *BreakerFile     `json:"breaker,omitempty"`
+	Retry       *RetryFile       `json:"retry,omitempty"`
+}
+
+// EndpointConfig 端點設定
+type EndpointConfig struct {
+	RPCAddress  string `json:"rpc_address"`
+	HTTPAddress string `json:"http_address,omitempty"`
+}
+
+// BreakerFile 設定檔中的斷路器設定
+type BreakerFile struct {
+	Window           Duration `json:"window,omitempty"`
+	MinRequests      int      `json:"min_requests,omitempty"`
+	FailureRate      float64  `json:"failure_rate,omitempty"`
+	CoolDown         Duration `json:"cool_down,omitempty"`
+	HalfOpenRequests int      `json:"half_open_requests,omitempty"`
+}
+
+// RetryFile 設定檔中的重試策略
+type RetryFile struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	InitialBackoff Duration `json:"initial_backoff,omitempty"`
+	MaxBackoff     Duration `json:"max_backoff,omitempty"`
+	Multiplier     float64  `json:"multiplier,omitempty"`
+	Jitter         float64  `json:"jitter,omitempty"`
+	RetryOn        []string `json:"retry_on,omitempty"`
+	Methods        []string `json:"methods"`
+}
+
+// LoadProxyConfig 讀取並驗證設定檔，依副檔名解析JSON、YAML或TOML
+// YAML與TOML只支援設定檔會用到的語法，轉為JSON後以相同的規則檢查
+func LoadProxyConfig(path string) (*ProxyConfig, error) {
+	var convert func([]byte) ([]byte, error)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", "":
+	case ".yaml", ".yml":
+		convert = yamlToJSON
+	case ".toml":
+		convert = tomlToJSON
+	default:
+		return nil, fmt.Errorf("config %s: unsupported format %q, use .json, .yaml, .yml or .toml", path, ext)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+	if convert != nil {
+		if b, err = convert(b); err != nil {
+			return nil, fmt.Errorf("config %s: %s", path, err)
+		}
+	}
+
+	config := new(ProxyConfig)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %s", path, err)
+	}
+	return config, nil
+}
+
+// Validate 檢查設定，回傳所有發現的錯誤
+func (config *ProxyConfig) Validate() error {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if config.HTTPAddress != "" && !validAddress(config.HTTPAddress) {
+		fail("http_address %q is not host:port", config.HTTPAddress)
+	}
+	if config.PrefixPath != "" && !strings.HasPrefix(config.PrefixPath, "/") {
+		fail("prefix_path %q must start with /", config.PrefixPath)
+	}
+	if config.BatchLimit < 0 {
+		fail("batch_limit must not be negative")
+	}
+	if config.HealthCheck != nil && config.HealthCheck.Interval <= 0 {
+		fail("health_check.interval must be positive")
+	}
+
+	names := map[string]bool{}
+	for i, sc := range config.Services {
+		field := fmt.Sprintf("services[%d]", i)
+		if sc.Name == "" {
+			fail("%s: name is required", field)
+		} else {
+			field = fmt.Sprintf("services[%d] (%s)", i, sc.Name)
+			if names[sc.Name] {
+				fail("%s: duplicate service name", field)
+			}
+			names[sc.Name] = true
+		}
+
+		if strings.Contains(sc.RPCName, ".") {
+			fail("%s: rpc_name %q must not contain a dot", field, sc.RPCName)
+		}
+
+		endpoints := sc.endpoints()
+		switch {
+		case sc.DNS != "" && len(endpoints) > 0:
+			fail("%s: dns cannot be used with rpc_address or endpoints", field)
+		case sc.DNS != "" && !strings.HasPrefix(sc.DNS, "_") && !validAddress(sc.DNS):
+			fail("%s: dns %q is neither host:port nor an SRV name", field, sc.DNS)
+		case sc.DNS == "" && len(endpoints) == 0:
+			fail("%s: rpc_address, endpoints or dns is required", field)
+		}
+		for _, ep := range endpoints {
+			if !validAddress(ep.RPCAddress) {
+				fail("%s: rpc_address %q is not host:port", field, ep.RPCAddress)
+			}
+			if ep.HTTPAddress != "" && !validAddress(ep.HTTPAddress) {
+				fail("%s: http_address %q is not host:port", field, ep.HTTPAddress)
+			}
+		}
+
+		switch sc.Balancer {
+		case "", "round_robin", "random", "least_pending":
+		case "hash":
+			if sc.HashField == "" {
+				fail("%s: hash_field is required for hash balancer", field)
+			}
+		default:
+			fail("%s: unknown balancer %q", field, sc.Balancer)
+		}
+		if sc.Timeout < 0 {
+			fail("%s: timeout must not be negative", field)
+		}
+		if sc.Breaker != nil && (sc.Breaker.FailureRate < 0 || sc.Breaker.FailureRate > 1) {
+			fail("%s: breaker.failure_rate must be between 0 and 1", field)
+		}
+		if sc.Retry != nil && len(sc.Retry.Methods) == 0 {
+			fail("%s: retry.methods is required", field)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// endpoints 合併單一位址與endpoints的設定
+func (sc ServiceConfig) endpoints() []EndpointConfig {
+	endpoints := []EndpointConfig{}
+	if sc.RPCAddress != "" {
+		endpoints = append(endpoints, EndpointConfig{sc.RPCAddress, sc.HTTPAddress})
+	}
+	return append(endpoints, sc.Endpoints...)
+}
+
+// options 轉為服務設定
+func (sc ServiceConfig) options() []ServiceOption {
+	opts := []ServiceOption{}
+	if sc.RPCName != "" {
+		opts = append(opts, WithRPCName(sc.RPCName))
+	}
+	switch sc.Balancer {
+	case "random":
+		opts = append(opts, WithBalancer(NewRandomBalancer()))
+	case "least_pending":
+		opts = append(opts, WithBalancer(NewLeastPendingBalancer()))
+	case "hash":
+		opts = append(opts, WithBalancer(NewHashBalancer(sc.HashField)))
+	}
+	if sc.Timeout > 0 {
+		opts = append(opts, WithTimeout(time.Duration(sc.Timeout)))
+	}
+	if b := sc.Breaker; b != nil {
+		opts = append(opts, WithBreaker(BreakerConfig{
+			Window:           time.Duration(b.Window),
+			MinRequests:      b.MinRequests,
+			FailureRate:      b.FailureRate,
+			CoolDown:         time.Duration(b.CoolDown),
+			HalfOpenRequests: b.HalfOpenRequests,
+		}))
+	}
+	if r := sc.Retry; r != nil {
+		opts = append(opts, WithRetry(RetryPolicy{
+			MaxAttempts:    r.MaxAttempts,
+			InitialBackoff: time.Duration(r.InitialBackoff),
+			MaxBackoff:     time.Duration(r.MaxBackoff),
+			Multiplier:     r.Multiplier,
+			Jitter:         r.Jitter,
+			RetryOn:        r.RetryOn,
+			Methods:        r.Methods,
+		}))
+	}
+	return opts
+}
+
+// service 依設定建立服務
+func (sc ServiceConfig) service() Service {
+	endpoints := sc.endpoints()
+	service := Service{
+		Name:     sc.Name,
+		DNS:      sc.DNS,
+		Balancer: NewRoundRobinBalancer(),
+	}
+	if len(endpoints) > 0 {
+		service.RPCAddress = endpoints[0].RPCAddress
+		service.HTTPAddress = endpoints[0].HTTPAddress
+	}
+	for _, ep := range endpoints {
+		service.Endpoints = append(service.Endpoints, &Endpoint{
+			RPCAddress:  ep.RPCAddress,
+			HTTPAddress: ep.HTTPAddress,
+		})
+	}
+	for _, opt := range sc.options() {
+		opt(&service)
+	}
+	service.setupBreakers()
+	return service
+}
+
+// validAddress 是否為 host:port 格式
+func validAddress(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
+
+// SetConfigFile 設定設定檔路徑，於Init時載入
+func (proxy *Proxy) SetConfigFile(path string) *Proxy {
+	proxy.configFile = path
+	return proxy
+}
+
+// LoadConfig 讀取設定檔並套用
+func (proxy *Proxy) LoadConfig(path string) error {
+	config, err := LoadProxyConfig(path)
+	if err != nil {
+		return err
+	}
+	proxy.ApplyConfig(config)
+	return nil
+}
+
+// ApplyConfig 套用設定，設定檔中的服務會加入或取代同名的服務，先前由設定檔載入的服務會被移除
+func (proxy *Proxy) ApplyConfig(config *ProxyConfig) *Proxy {
+	if config.HTTPAddress != "" {
+		proxy.SetHTTPAddress(config.HTTPAddress)
+	}
+	if config.PrefixPath != "" {
+		proxy.SetPrefixPath(config.PrefixPath)
+	}
+	if config.UI != nil {
+		proxy.EnableWebUI(*config.UI)
+	}
+	if config.Debug != nil {
+		proxy.DebugMode(*config.Debug)
+	}
+	if config.Legacy != nil {
+		proxy.EnableLegacy(*config.Legacy)
+	}
+	if config.AlwaysOK != nil {
+		proxy.AlwaysOK(*config.AlwaysOK)
+	}
+	if config.BatchLimit > 0 {
+		proxy.SetBatchLimit(config.BatchLimit)
+	}
+	if config.ReadTimeout > 0 {
+		proxy.SetReadTimeout(time.Duration(config.ReadTimeout))
+	}
+	if config.WriteTimeout > 0 {
+		proxy.SetWriteTimeout(time.Duration(config.WriteTimeout))
+	}
+	if hc := config.HealthCheck; hc != nil {
+		proxy.SetHealthCheck(time.Duration(hc.Interval), time.Duration(hc.Timeout))
+	}
+
+	proxy.replaceConfigServices(config.Services)
+	proxy.refreshAllDNS()
+	return proxy
+}
@@ -0,0 +1,148 @@
+package zrpc
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const jsonConfig = `{
+	"http_address": ":8081",
+	"prefix_path": "/rpc",
+	"ui": true,
+	"read_timeout": "10s",
+	"health_check": {"interval": "5s", "timeout": 1.5},
+	"services": [
+		{
+			"name": "Arith",
+			"rpc_name": "arith",
+			"endpoints": [
+				{"rpc_address": "10.0.0.2:50052", "http_address": "10.0.0.2:8000"},
+				{"rpc_address": "10.0.0.3:50052"}
+			],
+			"balancer": "least_pending",
+			"timeout": "3s",
+			"breaker": {"failure_rate": 0.5, "cool_down": "10s"},
+			"retry": {"max_attempts": 3, "methods": ["arith.Diff", "arith.Sum"]}
+		},
+		{"name": "Echo #1", "rpc_address": "127.0.0.1:50061"}
+	]
+}`
+
+const yamlConfig = `# proxy
+http_address: ":8081"
+prefix_path: /rpc
+ui: true
+read_timeout: 10s
+health_check:
+  interval: 5s
+  timeout: 1.5
+services:
+  - name: Arith
+    rpc_name: arith # backend name
+    endpoints:
+    - rpc_address: 10.0.0.2:50052
+      http_address: 10.0.0.2:8000
+    - {rpc_address: "10.0.0.3:50052"}
+    balancer: least_pending
+    timeout: 3s
+    breaker:
+      failure_rate: 0.5
+      cool_down: 10s
+    retry:
+      max_attempts: 3
+      methods: [arith.Diff, 'arith.Sum']
+  - name: "Echo #1"
+    rpc_address: 127.0.0.1:50061
+`
+
+const tomlConfig = `# proxy
+http_address = ":8081"
+prefix_path = "/rpc"
+ui = true
+read_timeout = "10s"
+health_check = { interval = "5s", timeout = 1.5 }
+
+[[services]]
+name = "Arith"
+rpc_name = 'arith' # backend name
+balancer = "least_pending"
+timeout = "3s"
+breaker.failure_rate = 0.5
+breaker.cool_down = "10s"
+
+[[services.endpoints]]
+rpc_address = "10.0.0.2:50052"
+http_address = "10.0.0.2:8000"
+
+[[services.endpoints]]
+rpc_address = "10.0.0.3:50052"
+
+[services.retry]
+max_attempts = 3
+methods = [
+	"arith.Diff",
+	"arith.Sum", # idempotent
+]
+
+[[services]]
+name = "Echo #1"
+rpc_address = "127.0.0.1:50061"
+`
+
+// writeConfig 寫入暫存的設定檔
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProxyConfigFormats(t *testing.T) {
+	want, err := LoadProxyConfig(writeConfig(t, "zrpc.json", jsonConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"zrpc.yaml": yamlConfig, "zrpc.yml": yamlConfig, "zrpc.toml": tomlConfig} {
+		config, err := LoadProxyConfig(writeConfig(t, name, body))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(config, want) {
+			t.Errorf("%s: got %+v, want %+v", name, config, want)
+		}
+	}
+}
+
+func TestLoadProxyConfigErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"unknown.yaml":  "services:\n  - name: Arith\n    rpc_adress: 127.0.0.1:50052\n",
+		"unknown.toml":  "[[services]]\nname = \"Arith\"\nrpc_adress = \"127.0.0.1:50052\"\n",
+		"invalid.yaml":  "services:\n  - name: Arith\n   rpc_address: 127.0.0.1:50052\n",
+		"anchor.yaml":   "services: &list\n  - name: Arith\n",
+		"bare.toml":     "[[services]]\nname = Arith\n",
+		"multi.toml":    "[[services]]\nname = \"\"\"Arith\"\"\"\n",
+		"duplicate.yml": "http_address: :8081\nhttp_address: :8082\n",
+		"zrpc.ini":      "",
+	} {
+		_, err := LoadProxyConfig(writeConfig(t, name, body))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	// 格式錯誤時指出行號
+	_, err := LoadProxyConfig(writeConfig(t, "line.yaml", "services:\n  - name: Arith\n   rpc_address: 127.0.0.1:50052\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("yaml error without line: %v", err)
+	}
+	_, err = LoadProxyConfig(writeConfig(t, "line.toml", "[[services]]\nname = Arith\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("toml error without line: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package zrpc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重新解析DNS的預設間隔
+const defaultDNSInterval = 10 * time.Second
+
+// 單次DNS解析的逾時
+const dnsTimeout = 5 * time.Second
+
+// Resolver DNS解析器，*net.Resolver 即符合此介面
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SetResolver 設定服務發現使用的DNS解析器
+func (proxy *Proxy) SetResolver(r Resolver) *Proxy {
+	proxy.resolver = r
+	return proxy
+}
+
+// SetDNSInterval 設定重新解析DNS的間隔，為0則只在新增服務時解析
+func (proxy *Proxy) SetDNSInterval(interval time.Duration) *Proxy {
+	proxy.dnsInterval = interval
+	return proxy
+}
+
+// DiscoverService 新增以DNS發現端點的服務，target 為 "host:port" (A/AAAA) 或 "_service._proto.name" (SRV)
+func (proxy *Proxy) DiscoverService(name, target string, opts ...ServiceOption) *Proxy {
+	if proxy.debug {
+		log.Println("[ZRPC] =============================")
+		log.Println("[ZRPC] 註冊新服務 ->", name)
+		log.Println("[ZRPC] DNS 服務位址 ->", target)
+		log.Println("[ZRPC] =============================")
+	}
+	proxy.Services.update(func(tx *registryTx) {
+		service, ok := tx.get(name)
+		if !ok {
+			service = Service{
+				Name:     name,
+				Balancer: NewRoundRobinBalancer(),
+			}
+		}
+		if service.DNS != target {
+			service.Endpoints = nil
+			service.RPCAddress = ""
+			service.HTTPAddress = ""
+		}
+		service.DNS = target
+		for _, opt := range opts {
+			opt(&service)
+		}
+		service.setupBreakers()
+		tx.put(service)
+	})
+
+	if err := proxy.refreshDNS(name); err != nil {
+		log.Printf("[ZRPC] DNS: service (%s) resolve %s failed -> %s", name, target, err)
+	}
+	return proxy
+}
+
+// discover 定期重新解析所有DNS服務，直到stop關閉
+func (proxy *Proxy) discover(stop chan int) {
+	if proxy.dnsInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(proxy.dnsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			proxy.refreshAllDNS()
+		}
+	}
+}
+
+// refreshAllDNS 重新解析所有DNS服務
+func (proxy *Proxy) refreshAllDNS() {
+	for name, service := range proxy.Services.table() {
+		if service.DNS == "" {
+			continue
+		}
+		if err := proxy.refreshDNS(name); err != nil {
+			log.Printf("[ZRPC] DNS: service (%s) resolve %s failed, keep current endpoints -> %s", name, service.DNS, err)
+		}
+	}
+}
+
+// refreshDNS 重新解析服務的DNS，位址有變更時更新端點
+func (proxy *Proxy) refreshDNS(name string) error {
+	service, ok := proxy.Services.Get(name)
+	if !ok || service.DNS == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	addresses, err := resolveTarget(ctx, proxy.resolver, service.DNS)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return fmt.Errorf("no address found")
+	}
+
+	var removed []*Endpoint
+	proxy.Services.update(func(tx *registryTx) {
+		prev, ok := tx.get(name)
+		if !ok || prev.DNS != service.DNS {
+			return
+		}
+
+		// 保留仍存在的端點，沿用其狀態
+		current := map[string]*Endpoint{}
+		for _, ep := range prev.Endpoints {
+			current[ep.RPCAddress] = ep
+		}
+		endpoints := []*Endpoint{}
+		changed := len(addresses) != len(prev.Endpoints)
+		for _, addr := range addresses {
+			ep, ok := current[addr]
+			if !ok {
+				ep = &Endpoint{RPCAddress: addr}
+				changed = true
+				log.Printf("[ZRPC] DNS: service (%s) endpoint %s added", name, addr)
+			}
+			endpoints = append(endpoints, ep)
+		}
+		if !changed {
+			return
+		}
+
+		service := prev
+		service.Endpoints = endpoints
+		service.RPCAddress = endpoints[0].RPCAddress
+		service.setupBreakers()
+		tx.put(service)
+		removed = removedEndpoints(map[string]Service{name: prev}, tx.services)
+	})
+
+	for _, ep := range removed {
+		log.Printf("[ZRPC] DNS: service (%s) endpoint %s removed", name, ep.RPCAddress)
+	}
+	go proxy.drainEndpoints(removed)
+	return nil
+}
+
+// resolveTarget 解析DNS目標為排序後的 host:port 位址
+func resolveTarget(ctx context.Context, r Resolver, target string) ([]string, error) {
+	addresses := []string{}
+	if strings.HasPrefix(target, "_") {
+		_, records, err := r.LookupSRV(ctx, "", "", target)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range records {
+			host := strings.TrimSuffix(srv.Target, ".")
+			addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			addresses = append(addresses, net.JoinHostPort(ip, port))
+		}
+	}
+	sort.Strings(addresses)
+
+	// 去除重複的位址
+	unique := addresses[:0]
+	for i, addr := range addresses {
+		if i == 0 || addr != addresses[i-1] {
+			unique = append(unique, addr)
+		}
+	}
+	return unique, nil
+}
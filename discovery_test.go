@@ -0,0 +1,170 @@
+package zrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// stubResolver 測試用的DNS解析器
+type stubResolver struct {
+	mx    sync.Mutex
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+	err   error
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.hosts[host], nil
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srv[name], nil
+}
+
+func (r *stubResolver) setHosts(host string, ips ...string) {
+	r.mx.Lock()
+	r.hosts[host] = ips
+	r.mx.Unlock()
+}
+
+func (r *stubResolver) setErr(err error) {
+	r.mx.Lock()
+	r.err = err
+	r.mx.Unlock()
+}
+
+func newStubResolver() *stubResolver {
+	return &stubResolver{hosts: map[string][]string{}, srv: map[string][]*net.SRV{}}
+}
+
+func endpointAddresses(s Service) []string {
+	addresses := []string{}
+	for _, ep := range s.Endpoints {
+		addresses = append(addresses, ep.RPCAddress)
+	}
+	return addresses
+}
+
+func TestResolveTargetA(t *testing.T) {
+	r := newStubResolver()
+	r.setHosts("arith.local", "10.0.0.2", "10.0.0.1", "10.0.0.2")
+
+	got, err := resolveTarget(context.Background(), r, "arith.local:50051")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:50051", "10.0.0.2:50051"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if _, err := resolveTarget(context.Background(), r, "arith.local"); err == nil {
+		t.Fatal("expected error for target without port")
+	}
+}
+
+func TestResolveTargetSRV(t *testing.T) {
+	r := newStubResolver()
+	r.srv["_rpc._tcp.arith.local"] = []*net.SRV{
+		{Target: "b.arith.local.", Port: 50052},
+		{Target: "a.arith.local.", Port: 50051},
+		{Target: "a.arith.local.", Port: 50051},
+	}
+
+	got, err := resolveTarget(context.Background(), r, "_rpc._tcp.arith.local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.arith.local:50051", "b.arith.local:50052"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverServiceScale(t *testing.T) {
+	r := newStubResolver()
+	r.setHosts("arith.local", "10.0.0.1")
+	proxy := NewProxy().SetResolver(r).SetDNSInterval(0)
+	proxy.DiscoverService("Arith", "arith.local:50051")
+
+	service, _ := proxy.Services.Get("Arith")
+	if got := endpointAddresses(service); !reflect.DeepEqual(got, []string{"10.0.0.1:50051"}) {
+		t.Fatalf("initial endpoints %v", got)
+	}
+	first := service.Endpoints[0]
+
+	// 擴充
+	r.setHosts("arith.local", "10.0.0.3", "10.0.0.1", "10.0.0.2")
+	if err := proxy.refreshDNS("Arith"); err != nil {
+		t.Fatal(err)
+	}
+	service, _ = proxy.Services.Get("Arith")
+	want := []string{"10.0.0.1:50051", "10.0.0.2:50051", "10.0.0.3:50051"}
+	if got := endpointAddresses(service); !reflect.DeepEqual(got, want) {
+		t.Fatalf("scale up endpoints %v, want %v", got, want)
+	}
+	if service.Endpoints[0] != first {
+		t.Fatal("existing endpoint should be kept on scale up")
+	}
+
+	// 縮減
+	r.setHosts("arith.local", "10.0.0.3")
+	if err := proxy.refreshDNS("Arith"); err != nil {
+		t.Fatal(err)
+	}
+	service, _ = proxy.Services.Get("Arith")
+	if got := endpointAddresses(service); !reflect.DeepEqual(got, []string{"10.0.0.3:50051"}) {
+		t.Fatalf("scale down endpoints %v", got)
+	}
+	if service.RPCAddress != "10.0.0.3:50051" {
+		t.Fatalf("rpc address %s", service.RPCAddress)
+	}
+}
+
+func TestDiscoverServiceResolveError(t *testing.T) {
+	r := newStubResolver()
+	r.srv["_rpc._tcp.arith.local"] = []*net.SRV{
+		{Target: "a.arith.local.", Port: 50051},
+		{Target: "b.arith.local.", Port: 50051},
+	}
+	proxy := NewProxy().SetResolver(r).SetDNSInterval(0)
+	proxy.DiscoverService("Arith", "_rpc._tcp.arith.local")
+	before, _ := proxy.Services.Get("Arith")
+
+	// 解析失敗時保留目前的端點
+	r.setErr(errors.New("server misbehaving"))
+	if err := proxy.refreshDNS("Arith"); err == nil {
+		t.Fatal("expected resolve error")
+	}
+	after, _ := proxy.Services.Get("Arith")
+	if !reflect.DeepEqual(endpointAddresses(after), endpointAddresses(before)) || len(after.Endpoints) != 2 {
+		t.Fatalf("endpoints changed after resolve error: %v", endpointAddresses(after))
+	}
+
+	// 解析不到任何位址也保留
+	r.setErr(nil)
+	r.mx.Lock()
+	r.srv["_rpc._tcp.arith.local"] = nil
+	r.mx.Unlock()
+	if err := proxy.refreshDNS("Arith"); err == nil {
+		t.Fatal("expected error for empty answer")
+	}
+	after, _ = proxy.Services.Get("Arith")
+	if len(after.Endpoints) != 2 {
+		t.Fatalf("endpoints changed after empty answer: %v", endpointAddresses(after))
+	}
+}
@@ -0,0 +1,369 @@
+package zrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go/token"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 方法回傳錯誤時送出的空回應，與 net/rpc 相同
+var invalidRequest = struct{}{}
+
+// methodType 可經由RPC呼叫的方法：func (t *T) Method(args T1, reply *T2) error
+type methodType struct {
+	method    reflect.Method
+	ArgType   reflect.Type
+	ReplyType reflect.Type
+}
+
+// rpcService 已註冊的服務
+type rpcService struct {
+	name    string
+	rcvr    reflect.Value
+	methods map[string]*methodType
+}
+
+// requestCodec 可取得目前請求期限與metadata的伺服端編碼器
+type requestCodec interface {
+	requestDeadline() time.Time
+	requestMetadata() map[string]string
+}
+
+// register 註冊服務，規則與錯誤訊息與 net/rpc 相同
+func (server *Server) register(rcvr interface{}, name string, useName bool) error {
+	s := &rpcService{rcvr: reflect.ValueOf(rcvr)}
+	typ := reflect.TypeOf(rcvr)
+	sname := reflect.Indirect(s.rcvr).Type().Name()
+	if useName {
+		sname = name
+	}
+	if sname == "" {
+		return errors.New("rpc.Register: no service name for type " + typ.String())
+	}
+	if !useName && !token.IsExported(sname) {
+		return errors.New("rpc.Register: type " + sname + " is not exported")
+	}
+	s.name = sname
+
+	s.methods = suitableMethods(typ)
+	if len(s.methods) == 0 {
+		msg := "rpc.Register: type " + sname + " has no exported methods of suitable type"
+		if len(suitableMethods(reflect.PtrTo(typ))) != 0 {
+			msg = "rpc.Register: type " + sname + " has no exported methods of suitable type (hint: pass a pointer to value of that type)"
+		}
+		return errors.New(msg)
+	}
+
+	server.servicesMx.Lock()
+	defer server.servicesMx.Unlock()
+	if _, dup := server.services[sname]; dup {
+		return errors.New("rpc: service already defined: " + sname)
+	}
+	if server.services == nil {
+		server.services = map[string]*rpcService{}
+	}
+	server.services[sname] = s
+	return nil
+}
+
+// suitableMethods 反映型別中可經由RPC呼叫的方法
+func suitableMethods(typ reflect.Type) map[string]*methodType {
+	methods := map[string]*methodType{}
+	for m := 0; m < typ.NumMethod(); m++ {
+		method := typ.Method(m)
+		mtype := method.Type
+		if method.PkgPath != "" || mtype.NumIn() != 3 || mtype.NumOut() != 1 {
+			continue
+		}
+		argType, replyType := mtype.In(1), mtype.In(2)
+		if !isExportedOrBuiltinType(argType) || replyType.Kind() != reflect.Ptr ||
+			!isExportedOrBuiltinType(replyType) || mtype.Out(0) != errorType {
+			continue
+		}
+		methods[method.Name] = &methodType{method: method, ArgType: argType, ReplyType: replyType}
+	}
+	return methods
+}
+
+// isExportedOrBuiltinType 型別是否為公開或內建型別
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return token.IsExported(t.Name()) || t.PkgPath() == ""
+}
+
+// lookup 依 "Service.Method" 取得服務與方法，錯誤訊息與 net/rpc 相同
+func (server *Server) lookup(serviceMethod string) (*rpcService, *methodType, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+	}
+	name, method := serviceMethod[:dot], serviceMethod[dot+1:]
+
+	server.servicesMx.RLock()
+	s, ok := server.services[name]
+	server.servicesMx.RUnlock()
+	if !ok {
+		return nil, nil, errors.New("rpc: can't find service " + serviceMethod)
+	}
+	mtype, ok := s.methods[method]
+	if !ok {
+		return nil, nil, errors.New("rpc: can't find method " + serviceMethod)
+	}
+	return s, mtype, nil
+}
+
+// checkMethod 依已註冊的服務檢查方法是否存在，找不到時回傳可用的服務或方法
+func (server *Server) checkMethod(serviceMethod string) (*rpcService, *methodType, error) {
+	s, mtype, err := server.lookup(serviceMethod)
+	if err == nil {
+		return s, mtype, nil
+	}
+
+	name := serviceMethod
+	if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+		name = serviceMethod[:dot]
+	}
+	server.servicesMx.RLock()
+	defer server.servicesMx.RUnlock()
+	s, ok := server.services[name]
+	if !ok {
+		services := []string{}
+		for name := range server.services {
+			services = append(services, name)
+		}
+		sort.Strings(services)
+		return nil, nil, &RPCError{Code: CodeMethodNotFound, Message: "Service Not Found", Data: map[string]interface{}{
+			"method":   serviceMethod,
+			"services": services,
+		}}
+	}
+
+	available := map[string]string{}
+	for method, mtype := range s.methods {
+		available[name+"."+method] = mtype.ArgType.String()
+	}
+	return nil, nil, &RPCError{Code: CodeMethodNotFound, Message: "Method not found", Data: map[string]interface{}{
+		"method":  serviceMethod,
+		"methods": available,
+	}}
+}
+
+// newArgs 建立要解碼的參數，回傳解碼目標與傳給方法的值
+func (mtype *methodType) newArgs() (target interface{}, arg func() interface{}) {
+	if mtype.ArgType.Kind() == reflect.Ptr {
+		argv := reflect.New(mtype.ArgType.Elem())
+		return argv.Interface(), argv.Interface
+	}
+	argv := reflect.New(mtype.ArgType)
+	return argv.Interface(), func() interface{} {
+		return argv.Elem().Interface()
+	}
+}
+
+// handler 實際執行方法的Handler
+func (s *rpcService) handler(mtype *methodType) Handler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		argv := reflect.ValueOf(req)
+		if !argv.IsValid() || argv.Type() != mtype.ArgType {
+			return nil, errors.New("rpc: argument type mismatch, expected " + mtype.ArgType.String())
+		}
+
+		replyv := reflect.New(mtype.ReplyType.Elem())
+		switch mtype.ReplyType.Elem().Kind() {
+		case reflect.Map:
+			replyv.Elem().Set(reflect.MakeMap(mtype.ReplyType.Elem()))
+		case reflect.Slice:
+			replyv.Elem().Set(reflect.MakeSlice(mtype.ReplyType.Elem(), 0, 0))
+		}
+
+		out := mtype.method.Func.Call([]reflect.Value{s.rcvr, argv, replyv})
+		if err := out[0].Interface(); err != nil {
+			return nil, err.(error)
+		}
+		return replyv.Interface(), nil
+	}
+}
+
+// run 經過攔截器執行呼叫，方法或攔截器panic時記錄堆疊並回傳代碼500的錯誤，連線與伺服器繼續服務
+func (server *Server) run(ctx context.Context, info *CallInfo, handler Handler, req interface{}) (reply interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			server.panics.Add(1)
+			log.Printf("[ZRPC] Panic in %s -> %v\n%s", info.ServiceMethod, p, debug.Stack())
+			reply, err = nil, Internal("Internal Server Error", map[string]string{
+				"method": info.ServiceMethod,
+			})
+		}
+	}()
+	return server.intercept(info, handler)(ctx, req)
+}
+
+// Panics 已從服務方法或攔截器的panic中恢復的次數
+func (server *Server) Panics() int64 {
+	return server.panics.Load()
+}
+
+// serveCodec 以編碼器服務連線，每個請求在各自的goroutine中經過攔截器後執行
+// 網路連線會套上逐則訊息的逾時設定
+func (server *Server) serveCodec(conn io.ReadWriteCloser, transport string, newCodec func(io.ReadWriteCloser) rpc.ServerCodec) {
+	var (
+		codec   rpc.ServerCodec
+		remote  string
+		sending sync.Mutex
+		wg      sync.WaitGroup
+	)
+	c, ok := conn.(net.Conn)
+	if ok {
+		remote = c.RemoteAddr().String()
+	}
+	if !ok || (server.idleTimeout <= 0 && server.readTimeout <= 0 && server.writeTimeout <= 0) {
+		codec = newCodec(conn)
+	} else {
+		tc := &timeoutConn{
+			Conn: c,
+			idle: server.idleTimeout,
+			read: server.readTimeout,
+		}
+		codec = &timeoutServerCodec{
+			ServerCodec: newCodec(tc),
+			conn:        tc,
+			write:       server.writeTimeout,
+		}
+	}
+
+	respond := func(req *rpc.Request, reply interface{}, errmsg string) {
+		resp := &rpc.Response{ServiceMethod: req.ServiceMethod, Seq: req.Seq, Error: errmsg}
+		if errmsg != "" {
+			reply = invalidRequest
+		}
+		sending.Lock()
+		err := codec.WriteResponse(resp, reply)
+		sending.Unlock()
+		if err != nil && server.debug {
+			log.Println("[ZRPC] rpc: writing response:", err)
+		}
+		server.tracker.end(conn)
+	}
+
+	for {
+		req := new(rpc.Request)
+		if err := codec.ReadRequestHeader(req); err != nil {
+			if err != io.EOF && server.debug {
+				log.Println("[ZRPC] rpc:", err)
+			}
+			break
+		}
+		server.tracker.begin(conn)
+
+		s, mtype, err := server.lookup(req.ServiceMethod)
+		if err != nil {
+			// 讀掉參數，維持資料流同步
+			codec.ReadRequestBody(nil)
+			respond(req, nil, err.Error())
+			continue
+		}
+		target, arg := mtype.newArgs()
+		if err := codec.ReadRequestBody(target); err != nil {
+			respond(req, nil, err.Error())
+			continue
+		}
+
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		info := &CallInfo{
+			ServiceMethod: req.ServiceMethod,
+			Transport:     transport,
+			RemoteAddr:    remote,
+		}
+		if rc, ok := codec.(requestCodec); ok {
+			if deadline := rc.requestDeadline(); !deadline.IsZero() {
+				ctx, cancel = context.WithDeadline(ctx, deadline)
+			}
+			info.Metadata = rc.requestMetadata()
+		}
+
+		wg.Add(1)
+		go func(req *rpc.Request) {
+			defer wg.Done()
+			defer cancel()
+			reply, err := server.run(ctx, info, s.handler(mtype), arg())
+			if err != nil {
+				respond(req, nil, err.Error())
+				return
+			}
+			respond(req, reply, "")
+		}(req)
+	}
+
+	// 等待處理中的請求回應後再關閉
+	wg.Wait()
+	codec.Close()
+}
+
+// callHTTP 在程序內呼叫已註冊的服務，經過與RPC相同的攔截器
+// 服務的一般錯誤轉為 rpc.ServerError，與經由RPC呼叫時相同
+func (server *Server) callHTTP(ctx context.Context, s *rpcService, mtype *methodType, data *Input) (interface{}, error) {
+	if data.Params == nil {
+		return nil, rpc.ServerError(errMissingParams.Error())
+	}
+	b, err := json.Marshal(data.Params)
+	if err != nil {
+		return nil, rpc.ServerError(err.Error())
+	}
+	target, arg := mtype.newArgs()
+	if err := json.Unmarshal(b, target); err != nil {
+		return nil, rpc.ServerError(err.Error())
+	}
+
+	info := &CallInfo{
+		ServiceMethod: data.Method,
+		Transport:     "http",
+		RemoteAddr:    remoteAddr(ctx),
+	}
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		reply, err := server.run(ctx, info, s.handler(mtype), arg())
+		done <- result{reply, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err == nil {
+			return res.reply, nil
+		}
+		// 服務轉回其他服務的 rpc.ServerError 時還原其中的ZRPC錯誤
+		err := wireError(res.err)
+		var rpcErr *RPCError
+		if _, ok := IsZrpcError(err); ok || errors.As(err, &rpcErr) || errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, rpc.ServerError(err.Error())
+	}
+}
+
+// remoteAddrKey context中HTTP請求來源位址的key
+type remoteAddrKey struct{}
+
+// remoteAddr 取得context中HTTP請求的來源位址
+func remoteAddr(ctx context.Context) string {
+	addr, _ := ctx.Value(remoteAddrKey{}).(string)
+	return addr
+}
@@ -0,0 +1,511 @@
+package zrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Arith 測試用的服務
+type Arith int
+
+// ArithArgs 測試用的參數，Sleep為回應前等待的時間
+type ArithArgs struct {
+	A, B  int
+	Sleep time.Duration
+}
+
+func (t *Arith) Sum(args *ArithArgs, reply *int) error {
+	if args.Sleep > 0 {
+		time.Sleep(args.Sleep)
+	}
+	*reply = args.A + args.B
+	return nil
+}
+
+func (t *Arith) Missing(args *ArithArgs, reply *int) error {
+	return NotFound("no such thing", map[string]int{"a": args.A})
+}
+
+func (t *Arith) Fail(args *ArithArgs, reply *int) error {
+	return errors.New("plain failure")
+}
+
+func (t *Arith) Boom(args *ArithArgs, reply *int) error {
+	panic("boom")
+}
+
+// 測試的RPC協定
+var rpcKinds = []string{"rpc", "jsonrpc"}
+
+// startTestServer 啟動同時服務RPC、JSON-RPC與HTTP的測試伺服器，測試結束時關閉
+func startTestServer(t *testing.T, interceptors ...Interceptor) *Server {
+	t.Helper()
+	server := NewServer().SetServer("both").
+		SetRPCAddress("127.0.0.1:0").
+		SetJSONRPCAddress("127.0.0.1:0").
+		SetHTTPAddress("127.0.0.1:0").
+		Use(interceptors...)
+	if err := server.RegisterName("arith", new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return server
+}
+
+// testClient 建立只有一條連線的客戶端
+func testClient(t *testing.T, server *Server, kind string) *Client {
+	t.Helper()
+	addr := server.JSONRPCNet.Addr().String()
+	if kind == "rpc" {
+		addr = server.RPCNet.Addr().String()
+	}
+	client := NewClient(addr).SetClient(kind).SetPoolSize(1)
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return client
+}
+
+// httpReply JSON-RPC 2.0 的回應
+type httpReply struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	} `json:"error"`
+	ID json.RawMessage `json:"id"`
+}
+
+// postHTTP 送出HTTP請求，回傳狀態碼與內容
+func postHTTP(t *testing.T, server *Server, body string, header http.Header) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest("POST", "http://"+server.HTTPNet.Addr().String()+"/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, b
+}
+
+// callHTTPMethod 以JSON-RPC 2.0經由HTTP呼叫方法
+func callHTTPMethod(t *testing.T, server *Server, method, params string, header http.Header) (int, httpReply) {
+	t.Helper()
+	status, b := postHTTP(t, server, fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":%s}`, method, params), header)
+	var reply httpReply
+	if err := json.Unmarshal(b, &reply); err != nil {
+		t.Fatalf("decode %s: %s", b, err)
+	}
+	return status, reply
+}
+
+// checkSum 確認連線仍可正常呼叫
+func checkSum(t *testing.T, client *Client) {
+	t.Helper()
+	var reply int
+	if err := client.Call("arith.Sum", &ArithArgs{A: 1, B: 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("follow-up call: %d %v", reply, err)
+	}
+}
+
+func TestDispatchUnknownMethod(t *testing.T) {
+	server := startTestServer(t)
+
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		for method, want := range map[string]string{
+			"arith.Nope": "rpc: can't find method arith.Nope",
+			"Nope.Sum":   "rpc: can't find service Nope.Sum",
+			"arith":      "rpc: service/method request ill-formed: arith",
+		} {
+			var reply int
+			err := client.Call(method, &ArithArgs{}, &reply)
+			var serverErr rpc.ServerError
+			if !errors.As(err, &serverErr) || string(serverErr) != want {
+				t.Errorf("%s %s: got %v, want %q", kind, method, err, want)
+			}
+		}
+		checkSum(t, client)
+	}
+
+	for _, method := range []string{"arith.Nope", "Nope.Sum"} {
+		status, reply := callHTTPMethod(t, server, method, `{}`, nil)
+		if status != http.StatusNotFound || reply.Error == nil || reply.Error.Code != CodeMethodNotFound {
+			t.Errorf("http %s: %d %+v", method, status, reply.Error)
+		}
+	}
+}
+
+func TestDispatchBadParams(t *testing.T) {
+	server := startTestServer(t)
+
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		var reply int
+		err := client.Call("arith.Sum", &struct{ A string }{A: "x"}, &reply)
+		var serverErr rpc.ServerError
+		if !errors.As(err, &serverErr) {
+			t.Errorf("%s: expected server error, got %v", kind, err)
+		}
+		checkSum(t, client)
+	}
+
+	for _, params := range []string{`{"A":"x"}`, `[1,2]`} {
+		status, reply := callHTTPMethod(t, server, "arith.Sum", params, nil)
+		if status != http.StatusBadRequest || reply.Error == nil || reply.Error.Code != CodeInvalidParams {
+			t.Errorf("http %s: %d %+v", params, status, reply.Error)
+		}
+	}
+}
+
+func TestDispatchMethodError(t *testing.T) {
+	server := startTestServer(t)
+
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		var reply int
+		err := client.Call("arith.Missing", &ArithArgs{A: 7}, &reply)
+		detail, ok := IsZrpcError(err)
+		if !ok || detail.Code != CodeNotFound || detail.Message != "no such thing" {
+			t.Errorf("%s Missing: %v", kind, err)
+		}
+
+		err = client.Call("arith.Fail", &ArithArgs{}, &reply)
+		var serverErr rpc.ServerError
+		if !errors.As(err, &serverErr) || string(serverErr) != "plain failure" || CodeOf(err) != CodeInternal {
+			t.Errorf("%s Fail: %v", kind, err)
+		}
+		checkSum(t, client)
+	}
+
+	status, reply := callHTTPMethod(t, server, "arith.Missing", `{"A":7}`, nil)
+	if status != http.StatusNotFound || reply.Error == nil || reply.Error.Code != 404 || reply.Error.Message != "no such thing" {
+		t.Errorf("http Missing: %d %+v", status, reply.Error)
+	}
+	status, reply = callHTTPMethod(t, server, "arith.Fail", `{}`, nil)
+	if status != http.StatusInternalServerError || reply.Error == nil || reply.Error.Code != CodeServerError || reply.Error.Message != "plain failure" {
+		t.Errorf("http Fail: %d %+v", status, reply.Error)
+	}
+}
+
+func TestDispatchPanic(t *testing.T) {
+	server := startTestServer(t)
+
+	for i, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		var reply int
+		err := client.Call("arith.Boom", &ArithArgs{}, &reply)
+		detail, ok := IsZrpcError(err)
+		if !ok || detail.Code != CodeInternal || detail.Message != "Internal Server Error" {
+			t.Errorf("%s: %v", kind, err)
+		}
+		checkSum(t, client)
+		if n := server.Panics(); n != int64(i+1) {
+			t.Errorf("%s: panics %d", kind, n)
+		}
+	}
+
+	status, reply := callHTTPMethod(t, server, "arith.Boom", `{}`, nil)
+	if status != http.StatusInternalServerError || reply.Error == nil || reply.Error.Code != 500 || reply.Error.Message != "Internal Server Error" {
+		t.Errorf("http: %d %+v", status, reply.Error)
+	}
+	status, reply = callHTTPMethod(t, server, "arith.Sum", `{"A":1,"B":2}`, nil)
+	if status != http.StatusOK || string(reply.Result) != "3" {
+		t.Errorf("http follow-up call: %d %s", status, reply.Result)
+	}
+	if n := server.Panics(); n != 3 {
+		t.Errorf("http: panics %d", n)
+	}
+}
+
+func TestDispatchDeadline(t *testing.T) {
+	var (
+		mx        sync.Mutex
+		deadlines = map[string]bool{}
+	)
+	server := startTestServer(t, func(ctx context.Context, info *CallInfo, req interface{}, next Handler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); ok {
+			mx.Lock()
+			deadlines[info.Transport] = true
+			mx.Unlock()
+		}
+		return next(ctx, req)
+	})
+
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		var reply int
+		err := client.CallContext(ctx, "arith", "Sum", &ArithArgs{A: 1, Sleep: 300 * time.Millisecond}, &reply)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || CodeOf(err) != CodeDeadlineExceeded {
+			t.Errorf("%s: expected deadline exceeded, got %v", kind, err)
+		}
+		checkSum(t, client)
+	}
+
+	status, reply := callHTTPMethod(t, server, "arith.Sum", `{"A":1,"Sleep":300000000}`, http.Header{TimeoutHeader: {"50ms"}})
+	if status != http.StatusGatewayTimeout || reply.Error == nil {
+		t.Errorf("http: %d %+v", status, reply.Error)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	for _, transport := range []string{"rpc", "jsonrpc", "http"} {
+		if !deadlines[transport] {
+			t.Errorf("%s: deadline not passed to the server", transport)
+		}
+	}
+}
+
+func TestDispatchConcurrent(t *testing.T) {
+	server := startTestServer(t)
+	const calls, delay = 20, 100 * time.Millisecond
+
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				err := client.Call("arith.Sum", &ArithArgs{A: i, B: 1000, Sleep: delay}, &reply)
+				if err != nil || reply != i+1000 {
+					t.Errorf("%s call %d: %d %v", kind, i, reply, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if elapsed := time.Since(start); elapsed >= calls*delay/2 {
+			t.Errorf("%s: calls on one connection were not concurrent (%s)", kind, elapsed)
+		}
+	}
+
+	// HTTP以批次請求同時執行
+	batch := []string{}
+	for i := 0; i < calls; i++ {
+		batch = append(batch, fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"arith.Sum","params":{"A":%d,"B":1000,"Sleep":%d}}`, i, i, delay))
+	}
+	start := time.Now()
+	status, b := postHTTP(t, server, "["+strings.Join(batch, ",")+"]", nil)
+	if elapsed := time.Since(start); elapsed >= calls*delay/2 {
+		t.Errorf("http: batch calls were not concurrent (%s)", elapsed)
+	}
+	var replies []httpReply
+	if err := json.Unmarshal(b, &replies); err != nil || status != http.StatusOK || len(replies) != calls {
+		t.Fatalf("http batch: %d %s %v", status, b, err)
+	}
+	for i, reply := range replies {
+		if want := fmt.Sprint(i + 1000); string(reply.ID) != fmt.Sprint(i) || string(reply.Result) != want {
+			t.Errorf("http batch %d: id %s result %s", i, reply.ID, reply.Result)
+		}
+	}
+}
+
+func TestDispatchShutdownDrain(t *testing.T) {
+	arrived := make(chan string, 3)
+	server := startTestServer(t, func(ctx context.Context, info *CallInfo, req interface{}, next Handler) (interface{}, error) {
+		arrived <- info.Transport
+		return next(ctx, req)
+	})
+	const delay = 300 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for _, kind := range rpcKinds {
+		client := testClient(t, server, kind)
+		wg.Add(1)
+		go func(kind string) {
+			defer wg.Done()
+			var reply int
+			if err := client.Call("arith.Sum", &ArithArgs{A: 1, B: 2, Sleep: delay}, &reply); err != nil || reply != 3 {
+				t.Errorf("%s in-flight call: %d %v", kind, reply, err)
+			}
+		}(kind)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		status, reply := callHTTPMethod(t, server, "arith.Sum", fmt.Sprintf(`{"A":1,"B":2,"Sleep":%d}`, delay), nil)
+		if status != http.StatusOK || string(reply.Result) != "3" {
+			t.Errorf("http in-flight call: %d %s %+v", status, reply.Result, reply.Error)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		<-arrived
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	wg.Wait()
+
+	// 關閉後不再接受新的連線
+	for _, kind := range rpcKinds {
+		var reply int
+		if err := testClient(t, server, kind).Call("arith.Sum", &ArithArgs{}, &reply); err == nil {
+			t.Errorf("%s: call succeeded after shutdown", kind)
+		}
+	}
+}
+
+func TestForwardInterceptors(t *testing.T) {
+	backend := startTestServer(t)
+	var (
+		mx    sync.Mutex
+		calls []CallInfo
+	)
+	server := startTestServer(t, func(ctx context.Context, info *CallInfo, req interface{}, next Handler) (interface{}, error) {
+		mx.Lock()
+		calls = append(calls, *info)
+		mx.Unlock()
+		if args, ok := req.(map[string]interface{}); ok && args["A"] == float64(0) {
+			return nil, Unauthenticated("denied", nil)
+		}
+		return next(ctx, req)
+	})
+	address := backend.JSONRPCNet.Addr().String()
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"arith.Sum","params":{"A":1,"B":2},"address":%q}`
+	status, b := postHTTP(t, server, fmt.Sprintf(body, address), nil)
+	if status != http.StatusOK || !strings.Contains(string(b), `"result":3`) {
+		t.Fatalf("forward: %d %s", status, b)
+	}
+
+	body = `{"jsonrpc":"2.0","id":1,"method":"arith.Sum","params":{"A":0,"B":2},"address":%q}`
+	status, b = postHTTP(t, server, fmt.Sprintf(body, address), nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("forward was not intercepted: %d %s", status, b)
+	}
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(calls) != 2 || calls[0].Transport != "http" || calls[0].ServiceMethod != "arith.Sum" {
+		t.Fatalf("interceptor calls: %+v", calls)
+	}
+}
+
+func TestForwardMethodError(t *testing.T) {
+	backend := startTestServer(t)
+	address := backend.JSONRPCNet.Addr().String()
+
+	// 後端的ZRPC錯誤經過轉送仍以其代碼決定HTTP狀態碼
+	server := startTestServer(t)
+	body := `{"jsonrpc":"2.0","id":1,"method":"arith.Missing","params":{"A":7},"address":%q}`
+	status, b := postHTTP(t, server, fmt.Sprintf(body, address), nil)
+	if status != http.StatusNotFound || !strings.Contains(string(b), `"code":404`) {
+		t.Errorf("forward Missing: %d %s", status, b)
+	}
+
+	proxy := NewProxy().AddService("Arith", address, "", WithRPCName("arith"))
+	proxy.PrefixPath = "/"
+	defer proxy.client.Close()
+	for method, want := range map[string]int{
+		"Missing": http.StatusNotFound,
+		"Fail":    http.StatusInternalServerError,
+		"Nope":    http.StatusNotFound,
+	} {
+		body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"service":"Arith","method":%q,"params":{"A":7}}`, method)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+		var reply httpReply
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Fatalf("decode %s: %s", w.Body.String(), err)
+		}
+		if w.Code != want || reply.Error == nil {
+			t.Errorf("proxy %s: %d %s", method, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestLegacyErrorCode(t *testing.T) {
+	server := startTestServer(t)
+	server.EnableLegacy(true)
+
+	for body, want := range map[string]int{
+		`{"method":"arith.Nope","params":{}}`:       http.StatusNotFound,
+		`{"method":"arith.Sum"}`:                    http.StatusBadRequest,
+		`{"method":"arith.Sum","params":{"A":"x"}}`: http.StatusBadRequest,
+		`{"method":"arith.Fail","params":{}}`:       http.StatusInternalServerError,
+	} {
+		status, b := postHTTP(t, server, body, nil)
+		var reply struct {
+			Error *ErrorDetail `json:"error"`
+		}
+		if err := json.Unmarshal(b, &reply); err != nil {
+			t.Fatalf("decode %s: %s", b, err)
+		}
+		if status != want || reply.Error == nil || reply.Error.Code != strconv.Itoa(want) {
+			t.Errorf("%s: %d %s", body, status, b)
+		}
+	}
+}
+
+func TestForwardMissingParams(t *testing.T) {
+	backend := startTestServer(t)
+	address := backend.JSONRPCNet.Addr().String()
+
+	server := startTestServer(t)
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"arith.Sum","address":%q}`, address)
+	status, b := postHTTP(t, server, body, nil)
+	if status != http.StatusBadRequest || !strings.Contains(string(b), `"code":-32602`) {
+		t.Errorf("forward: %d %s", status, b)
+	}
+
+	proxy := NewProxy().AddService("Arith", address, "", WithRPCName("arith"))
+	proxy.PrefixPath = "/"
+	defer proxy.client.Close()
+	w := httptest.NewRecorder()
+	body = `{"jsonrpc":"2.0","id":1,"service":"Arith","method":"Sum"}`
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":-32602`) {
+		t.Errorf("proxy: %d %s", w.Code, w.Body.String())
+	}
+
+	// 舊格式的null參數同樣不轉送
+	proxy.EnableLegacy(true)
+	w = httptest.NewRecorder()
+	body = `{"service":"Arith","method":"Sum","params":null}`
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"400"`) {
+		t.Errorf("proxy legacy: %d %s", w.Code, w.Body.String())
+	}
+}
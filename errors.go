@@ -0,0 +1,196 @@
+package zrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"sync"
+)
+
+// 內建的錯誤代碼
+const (
+	CodeInvalidArgument   = "400"
+	CodeUnauthenticated   = "401"
+	CodePermissionDenied  = "403"
+	CodeNotFound          = "404"
+	CodeAlreadyExists     = "409"
+	CodeUnprocessable     = "422"
+	CodeResourceExhausted = "429"
+	CodeCanceled          = "499"
+	CodeInternal          = "500"
+	CodeUnimplemented     = "501"
+	CodeUnavailable       = "503"
+	CodeDeadlineExceeded  = "504"
+)
+
+// CodeInfo 錯誤代碼的說明
+type CodeInfo struct {
+	Code   string // 錯誤代碼，可為數字或字串，如 "404"、"E_TOKEN"
+	Name   string // 名稱，如 "NotFound"
+	Status int    // 對應的HTTP狀態碼
+}
+
+var codes = struct {
+	mx    sync.RWMutex
+	infos map[string]CodeInfo
+}{infos: map[string]CodeInfo{}}
+
+func init() {
+	for _, info := range []CodeInfo{
+		{CodeInvalidArgument, "InvalidArgument", http.StatusBadRequest},
+		{CodeUnauthenticated, "Unauthenticated", http.StatusUnauthorized},
+		{CodePermissionDenied, "PermissionDenied", http.StatusForbidden},
+		{CodeNotFound, "NotFound", http.StatusNotFound},
+		{CodeAlreadyExists, "AlreadyExists", http.StatusConflict},
+		{CodeUnprocessable, "Unprocessable", http.StatusUnprocessableEntity},
+		{CodeResourceExhausted, "ResourceExhausted", http.StatusTooManyRequests},
+		{CodeCanceled, "Canceled", 499},
+		{CodeInternal, "Internal", http.StatusInternalServerError},
+		{CodeUnimplemented, "Unimplemented", http.StatusNotImplemented},
+		{CodeUnavailable, "Unavailable", http.StatusServiceUnavailable},
+		{CodeDeadlineExceeded, "DeadlineExceeded", http.StatusGatewayTimeout},
+	} {
+		RegisterCode(info)
+	}
+}
+
+// RegisterCode 註冊錯誤代碼，已存在的代碼會被取代
+func RegisterCode(info CodeInfo) {
+	codes.mx.Lock()
+	defer codes.mx.Unlock()
+	codes.infos[info.Code] = info
+}
+
+// LookupCode 查詢錯誤代碼，未註冊但為HTTP狀態碼(400~599)的數字代碼也視為已知
+func LookupCode(code string) (CodeInfo, bool) {
+	codes.mx.RLock()
+	info, ok := codes.infos[code]
+	codes.mx.RUnlock()
+	if ok {
+		return info, true
+	}
+	if status, err := strconv.Atoi(code); err == nil && status >= 400 && status < 600 {
+		return CodeInfo{Code: code, Name: http.StatusText(status), Status: status}, true
+	}
+	return CodeInfo{}, false
+}
+
+// NotFound 找不到資源
+func NotFound(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeNotFound, message, data)
+}
+
+// InvalidArgument 參數錯誤
+func InvalidArgument(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeInvalidArgument, message, data)
+}
+
+// Unauthenticated 未驗證身分
+func Unauthenticated(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeUnauthenticated, message, data)
+}
+
+// PermissionDenied 沒有權限
+func PermissionDenied(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodePermissionDenied, message, data)
+}
+
+// AlreadyExists 資源已存在
+func AlreadyExists(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeAlreadyExists, message, data)
+}
+
+// ResourceExhausted 超過使用限制
+func ResourceExhausted(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeResourceExhausted, message, data)
+}
+
+// Internal 內部錯誤
+func Internal(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeInternal, message, data)
+}
+
+// Unimplemented 尚未實作
+func Unimplemented(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeUnimplemented, message, data)
+}
+
+// Unavailable 服務無法使用
+func Unavailable(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeUnavailable, message, data)
+}
+
+// DeadlineExceeded 超過期限
+func DeadlineExceeded(message string, data interface{}) *ErrorDetail {
+	return NewZrpcError(CodeDeadlineExceeded, message, data)
+}
+
+// CodeOf 取得錯誤代碼，連線失敗為503，逾時為504，呼叫端取消為499，服務回傳的一般錯誤為500
+func CodeOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	if detail, ok := IsZrpcError(err); ok {
+		return detail.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CodeDeadlineExceeded
+	}
+	if errors.Is(err, context.Canceled) {
+		return CodeCanceled
+	}
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		return CodeInternal
+	}
+	return CodeUnavailable
+}
+
+// IsCode 錯誤是否為指定的代碼
+func IsCode(err error, code string) bool {
+	return err != nil && CodeOf(err) == code
+}
+
+// StatusOf 取得錯誤對應的HTTP狀態碼，未知的代碼為500
+func StatusOf(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if info, ok := LookupCode(CodeOf(err)); ok && info.Status != 0 {
+		return info.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// parseErrorDetail 解析由ErrorDetail.Error()產生的字串，必須是只含code、message、data且code不為空的物件
+func parseErrorDetail(s string) (*ErrorDetail, bool) {
+	b := bytes.TrimSpace([]byte(s))
+	if len(b) == 0 || b[0] != '{' {
+		return nil, false
+	}
+	var detail ErrorDetail
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&detail); err != nil || detail.Code == "" || dec.More() {
+		return nil, false
+	}
+	return &detail, true
+}
+
+// wireError 將伺服端回傳的ZRPC錯誤還原為 *ErrorDetail，仍可用errors.As取得原本的 rpc.ServerError
+func wireError(err error) error {
+	var serverErr rpc.ServerError
+	if !errors.As(err, &serverErr) {
+		return err
+	}
+	detail, ok := parseErrorDetail(string(serverErr))
+	if !ok {
+		return err
+	}
+	detail.cause = serverErr
+	return detail
+}
@@ -4,8 +4,6 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"time"
 
 	"github.com/yam8511/zrpc"
@@ -46,13 +44,21 @@ func (t *Arith) Diff(args *Args, diff *int) error {
 
 func main() {
 	server := zrpc.NewServer()
-	// server.SetServer("rpc")
 	isClient := flag.Bool("c", false, "if run client")
+	kind := flag.String("k", "jsonrpc", "server kind: rpc, jsonrpc or both")
 	flag.Parse()
+	server.SetServer(*kind)
 
 	if *isClient {
-		// runRPCClient(server.GetRPCAddress())
-		runJSONRPCClient(server.GetJSONRPCAddress())
+		switch *kind {
+		case "rpc":
+			runRPCClient(server.GetRPCAddress())
+		case "both":
+			runRPCClient(server.GetRPCAddress())
+			runJSONRPCClient(server.GetJSONRPCAddress())
+		default:
+			runJSONRPCClient(server.GetJSONRPCAddress())
+		}
 		return
 	}
 
@@ -65,16 +71,13 @@ func main() {
 }
 
 func runRPCClient(address string) {
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		log.Fatal(err)
-	}
+	client := zrpc.NewClient(address).SetClient("rpc")
 	defer client.Close()
 
 	var args interface{}
 	args = &Args{7, 8}
 	var sum int
-	err = client.Call("arith.Sum", args, &sum)
+	err := client.Call("arith.Sum", args, &sum)
 	if err != nil {
 		jerr, yes := zrpc.IsZrpcError(err)
 		if yes {
@@ -87,16 +90,13 @@ func runRPCClient(address string) {
 }
 
 func runJSONRPCClient(address string) {
-	client, err := jsonrpc.Dial("tcp", address)
-	if err != nil {
-		log.Fatal(err)
-	}
+	client := zrpc.NewClient(address)
 	defer client.Close()
 
 	var args interface{}
 	args = &Args{7, 8}
 	var sum int
-	err = client.Call("arith.Sum", args, &sum)
+	err := client.Call("arith.Sum", args, &sum)
 	if err != nil {
 		jerr, yes := zrpc.IsZrpcError(err)
 		if yes {
@@ -107,14 +107,3 @@ func runJSONRPCClient(address string) {
 	}
 	fmt.Printf("Arith: req -> %v , res -> %v\n", args, sum)
 }
-
-func transferJSONRPCClient(address, method string, params interface{}) (res interface{}, err error) {
-	client, dialErr := jsonrpc.Dial("tcp", address)
-	if dialErr != nil {
-		err = dialErr
-		return
-	}
-	defer client.Close()
-	err = client.Call(method, params, &res)
-	return
-}
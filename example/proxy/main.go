@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"time"
 
 	"github.com/yam8511/zrpc"
@@ -40,19 +41,25 @@ func (t *Arith) Diff(args *Args, diff *int) error {
 }
 
 func main() {
+	// 伺服器與代理共用同一個訊號context
+	ctx, stop := zrpc.SignalContext(context.Background())
+	defer stop()
+
 	arith := new(Arith)
 	server := zrpc.NewServer()
 	server.RegisterName("arith", arith)
+	served := make(chan error, 1)
 	go func() {
-		if err := server.Listen(); err != nil {
-			panic(err)
-		}
+		served <- server.Serve(ctx)
 	}()
 
-	proxy := zrpc.NewProxy()
-	proxy.AddService("Arith", server.GetJSONRPCAddress(), server.GetHTTPAddress())
-	err := proxy.Listen()
-	if err != nil {
+	// 使用Serve時訊號由呼叫端處理，需自行開啟SIGHUP重新載入設定檔
+	proxy := zrpc.NewProxy().ReloadOnSIGHUP(true)
+	proxy.AddService("Arith", server.GetJSONRPCAddress(), server.GetHTTPAddress(), zrpc.WithRPCName("arith"), zrpc.WithTimeout(3*time.Second))
+	if err := proxy.Serve(ctx); err != nil {
+		panic(err)
+	}
+	if err := <-served; err != nil {
 		panic(err)
 	}
 }
@@ -1,14 +1,21 @@
 package zrpc
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/http/pprof"
-	"net/rpc/jsonrpc"
+	"net/rpc"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// TimeoutHeader 請求逾時的Header，可為毫秒數或時間字串(如 1.5s)
+const TimeoutHeader = "X-Zrpc-Timeout"
+
 // ServeHTTP 服務處理
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.RequestURI == "/favicon.ico" {
@@ -16,12 +23,6 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip := r.RemoteAddr
-	server.httpIn <- ip
-	defer func(ip string) {
-		server.httpOut <- ip
-	}(ip)
-
 	if server.debug {
 		switch r.URL.EscapedPath() {
 		case "/debug/pprof/cmdline":
@@ -48,91 +49,78 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if r.URL.EscapedPath() == "/services" {
-		err := json.NewEncoder(w).Encode(server.Services)
+	if r.URL.EscapedPath() == "/health" {
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "ok",
+			"panics": server.Panics(),
+		})
 		if err != nil {
 			log.Println("[ZRPC] Response Error ->", err)
-			return
 		}
 		return
 	}
 
-	var data Input
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		err = json.NewEncoder(w).Encode(Output{
-			Result: nil,
-			Error: ErrorDetail{
-				Code:    "500",
-				Message: err.Error(),
-				Data:    err,
-			},
-			ID: data.ID,
-		})
+	if r.URL.EscapedPath() == "/services" {
+		err := json.NewEncoder(w).Encode(server.Services)
 		if err != nil {
 			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 		return
 	}
-	address := data.Address
-	if address == "" {
-		address = server.GetJSONRPCAddress()
-	}
-	res, err := transferJSONRPCClient(address, data.Method, data.Params)
-	if err != nil {
-		output := Output{
-			Result: nil,
-			Error:  nil,
-			ID:     data.ID,
-		}
 
-		jsonrpcErr, yes := IsZrpcError(err)
-		if yes {
-			output.Error = jsonrpcErr
-		} else {
-			log.Println("[ZRPC] JSON DECODE Error ->", err)
-			output.Error = ErrorDetail{
-				Code:    "500",
-				Message: err.Error(),
-				Data:    err,
-			}
-		}
+	server.gateway.serve(w, r, server.handle)
+}
 
-		err = json.NewEncoder(w).Encode(output)
-		if err != nil {
-			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
+// handle 將呼叫轉送到RPC服務
+func (server *Server) handle(ctx context.Context, data *Input) (res interface{}, err error) {
+	data.Method = composeMethod(data.Service, data.Method)
+	if data.Address != "" {
+		if data.Params == nil {
+			return nil, missingParams(data.Method)
+		}
+		// 轉送到其他伺服器，同樣經過攔截器，req為未解碼的參數，方法由對方檢查
+		info := &CallInfo{
+			ServiceMethod: data.Method,
+			Transport:     "http",
+			RemoteAddr:    remoteAddr(ctx),
+		}
+		res, err = server.run(ctx, info, func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			err = server.client.call(ctx, data.Address, data.Method, req, &reply)
+			return
+		}, data.Params)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errDeadlineExceeded
 		}
 		return
 	}
 
-	err = json.NewEncoder(w).Encode(Output{
-		Result: res,
-		Error:  nil,
-		ID:     data.ID,
-	})
+	s, mtype, err := server.checkMethod(data.Method)
 	if err != nil {
-		log.Println("[ZRPC] Response Error ->", err)
-		return
+		return nil, err
+	}
+	res, err = server.callHTTP(ctx, s, mtype, data)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = errDeadlineExceeded
+	}
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) && invalidParams(string(serverErr)) {
+		err = &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: map[string]interface{}{
+			"method": data.Method,
+			"params": mtype.ArgType.String(),
+			"error":  string(serverErr),
+		}}
 	}
+	return
 }
 
 // ServeHTTP 服務處理
 func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.URL.EscapedPath() == "/registry" {
-		var s []Service
-		for _, srv := range proxy.Services {
-			s = append(s, srv)
-		}
-		err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"services": s,
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"services": proxy.Services.List(),
 		})
-		if err != nil {
-			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
 		return
 	}
 
@@ -156,102 +144,131 @@ func (proxy *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var data Input
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		err = json.NewEncoder(w).Encode(Output{
-			Result: nil,
-			Error: ErrorDetail{
-				Code:    "500",
-				Message: err.Error(),
-				Data:    err,
-			},
-			ID: data.ID,
-		})
-		if err != nil {
-			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
+	proxy.gateway.serve(w, r, proxy.handle)
+}
+
+// handle 依服務名稱將呼叫轉送到服務
+func (proxy *Proxy) handle(ctx context.Context, data *Input) (interface{}, error) {
+	service, ok := proxy.Services.Get(data.Service)
+	if !ok {
+		return nil, &RPCError{
+			Code:    CodeMethodNotFound,
+			Message: "Service Not Found",
+			Data:    "Service: " + data.Service,
 		}
-		return
 	}
+	data.Method = service.rpcMethod(data.Method)
+	if data.Params == nil {
+		return nil, missingParams(data.Method)
+	}
+	return proxy.forward(ctx, service, data)
+}
+
+// missingParams 沒有參數時不轉送，與本地呼叫同樣回應Invalid params
+func missingParams(method string) error {
+	return &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: map[string]interface{}{
+		"method": method,
+		"error":  errMissingParams.Error(),
+	}}
+}
 
-	// 先接收輸入的adress
-	var address = data.Address
+// forward 將請求轉送到服務，冪等方法依服務的重試策略重試
+func (proxy *Proxy) forward(ctx context.Context, service Service, data *Input) (res interface{}, err error) {
+	// 套用服務的呼叫逾時
+	if service.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, service.Timeout)
+		defer cancel()
+	}
 
-	// 檢查服務是否存在
-	service, ok := proxy.Services[data.Service]
-	if !ok {
-		err = json.NewEncoder(w).Encode(Output{
-			Result: nil,
-			Error: ErrorDetail{
-				Code:    "500",
-				Message: "Service Not Found",
-				Data:    "Service: " + data.Service,
-			},
-			ID: data.ID,
+	err = retry(ctx, service.Retry, data.Method, func() error {
+		res = nil
+		return proxy.forwardOnce(ctx, service, data, &res)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = DeadlineExceeded("Deadline Exceeded", map[string]string{
+			"service": service.Name,
+			"timeout": service.Timeout.String(),
 		})
-		if err != nil {
-			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		return
 	}
-	// 如果沒有輸入address，取註冊服務的address
+	return
+}
+
+// forwardOnce 挑選端點並轉送一次請求
+func (proxy *Proxy) forwardOnce(ctx context.Context, service Service, data *Input, res *interface{}) error {
+	// 服務斷路時直接回應
+	if !service.Breaker.Allow() {
+		return Unavailable("Circuit Breaker Open", "Service: "+data.Service)
+	}
+
+	// 如果沒有輸入address，由負載平衡挑選註冊服務的address
+	address := data.Address
+	var endpoint *Endpoint
 	if address == "" {
-		address = service.RPCAddress
+		// 端點斷路器拒絕時排除該端點重新挑選
+		var refused []*Endpoint
+		for {
+			endpoint = service.pick(data, refused...)
+			if endpoint == nil {
+				// 請求未送到後端，不計入服務斷路器
+				service.Breaker.release()
+				return Unavailable("No Available Endpoint", "Service: "+data.Service)
+			}
+			if endpoint.Breaker.Allow() {
+				break
+			}
+			refused = append(refused, endpoint)
+		}
+		address = endpoint.RPCAddress
+		endpoint.pending.Add(1)
+		defer endpoint.pending.Add(-1)
 	}
 
 	if proxy.debug {
 		log.Printf("[ZRPC] Server (%s), Redirect to %s", service.Name, address)
 	}
 
-	res, err := transferJSONRPCClient(address, data.Method, data.Params)
-	if err != nil {
-		output := Output{
-			Result: nil,
-			Error:  nil,
-			ID:     data.ID,
-		}
-
-		jsonrpcErr, yes := IsZrpcError(err)
-		if yes {
-			output.Error = jsonrpcErr
-		} else {
-			log.Println("[ZRPC] JSON DECODE Error ->", err)
-			output.Error = ErrorDetail{
-				Code:    "500",
-				Message: err.Error(),
-				Data:    err,
-			}
+	err := proxy.client.callWith(ctx, &CallInfo{
+		ServiceMethod: data.Method,
+		Transport:     proxy.client.kind,
+		RemoteAddr:    address,
+		Service:       service.Name,
+	}, data.Params, res)
+	if errors.Is(err, context.Canceled) {
+		// 呼叫端放棄，不計入斷路器
+		service.Breaker.release()
+		if endpoint != nil {
+			endpoint.Breaker.release()
 		}
+		return err
+	}
+	service.Breaker.Done(!isFailure(err))
+	if endpoint != nil {
+		endpoint.Breaker.Done(!isFailure(err))
+	}
+	return err
+}
 
-		err = json.NewEncoder(w).Encode(output)
-		if err != nil {
-			log.Println("[ZRPC] Response Error ->", err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		return
+// requestContext 由請求建立ctx，並套用逾時Header
+func requestContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	ctx := context.WithValue(r.Context(), remoteAddrKey{}, r.RemoteAddr)
+	value := r.Header.Get(TimeoutHeader)
+	if value == "" {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, nil
 	}
 
-	err = json.NewEncoder(w).Encode(Output{
-		Result: res,
-		Error:  nil,
-		ID:     data.ID,
-	})
+	timeout, err := time.ParseDuration(value)
 	if err != nil {
-		log.Println("[ZRPC] Response Error ->", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		ms, e := strconv.ParseInt(value, 10, 64)
+		if e != nil {
+			return nil, nil, err
+		}
+		timeout = time.Duration(ms) * time.Millisecond
 	}
-}
-
-func transferJSONRPCClient(address, method string, params interface{}) (res interface{}, err error) {
-	client, dialErr := jsonrpc.Dial("tcp", address)
-	if dialErr != nil {
-		err = dialErr
-		return
+	if timeout <= 0 {
+		return nil, nil, errors.New("timeout must be positive")
 	}
-	defer client.Close()
-	err = client.Call(method, params, &res)
-	return
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
 }
@@ -0,0 +1,93 @@
+package zrpc
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 健康檢查預設的逾時
+const defaultHealthTimeout = 3 * time.Second
+
+// SetHealthCheck 設定健康檢查的間隔與逾時，間隔為0則不檢查
+func (proxy *Proxy) SetHealthCheck(interval, timeout time.Duration) *Proxy {
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	proxy.healthInterval = interval
+	proxy.healthTimeout = timeout
+	return proxy
+}
+
+// healthCheck 定期檢查所有端點，直到stop關閉
+func (proxy *Proxy) healthCheck(stop chan int) {
+	if proxy.healthInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(proxy.healthInterval)
+	defer ticker.Stop()
+	for {
+		proxy.checkEndpoints()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkEndpoints 同時檢查所有端點並更新狀態
+func (proxy *Proxy) checkEndpoints() {
+	type target struct {
+		service  string
+		endpoint *Endpoint
+	}
+	var targets []target
+	for name, service := range proxy.Services.table() {
+		for _, ep := range service.Endpoints {
+			targets = append(targets, target{name, ep})
+		}
+	}
+
+	wg := new(sync.WaitGroup)
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			err := probe(t.endpoint, proxy.healthTimeout)
+			if t.endpoint.setHealthy(err == nil) {
+				if err == nil {
+					log.Printf("[ZRPC] Service (%s) endpoint %s is UP", t.service, t.endpoint.RPCAddress)
+				} else {
+					log.Printf("[ZRPC] Service (%s) endpoint %s is DOWN -> %s", t.service, t.endpoint.RPCAddress, err)
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// probe 檢查端點，有HTTP位址時請求 /health，否則只嘗試建立RPC連線
+func probe(ep *Endpoint, timeout time.Duration) error {
+	if ep.HTTPAddress == "" {
+		conn, err := net.DialTimeout("tcp", ep.RPCAddress, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get("http://" + ep.HTTPAddress + "/health")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return NewZrpcError(strconv.Itoa(res.StatusCode), "Health Check Failed", ep.HTTPAddress)
+	}
+	return nil
+}
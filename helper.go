@@ -1,87 +1,21 @@
 package zrpc
 
-import (
-	"encoding/json"
-	"log"
-	"os"
-)
+import "errors"
 
-// 偵測訊號
-func (server *Server) delectSignal(done bool, sig chan os.Signal, prevSig os.Signal) (os.Signal, bool) {
-	if done {
-		return prevSig, true
-	}
-	select {
-	case s := <-sig:
-		return s, true
-	default:
+// IsZrpcError 是否為套件的錯誤型態，不解析錯誤字串
+// 經由RPC收到的錯誤已由客戶端還原為 *ErrorDetail
+func IsZrpcError(e error) (detail *ErrorDetail, yes bool) {
+	if e == nil {
 		return nil, false
 	}
-}
-
-// 等待連線結束
-func (server *Server) waitConnection(done bool, sig chan os.Signal, prevSig os.Signal, e chan error) (bool, os.Signal, error) {
-	if done {
-		select {
-		case ip := <-server.rpcIn:
-			server.online++
-			if server.debug {
-				log.Println("CONNECT ->", ip)
-			}
-		case ip := <-server.httpIn:
-			server.online++
-			if server.debug {
-				log.Println("CONNECT ->", ip)
-			}
-		case ip := <-server.rpcOut:
-			server.online--
-			if server.debug {
-				log.Println("DISCONNECT ->", ip)
-			}
-		case ip := <-server.httpOut:
-			server.online--
-			if server.debug {
-				log.Println("DISCONNECT ->", ip)
-			}
-		case err := <-e:
-			return false, prevSig, err
-		}
-	} else {
-		select {
-		case ip := <-server.rpcIn:
-			server.online++
-			if server.debug {
-				log.Println("CONNECT ->", ip)
-			}
-		case ip := <-server.httpIn:
-			server.online++
-			if server.debug {
-				log.Println("CONNECT ->", ip)
-			}
-		case ip := <-server.rpcOut:
-			server.online--
-			if server.debug {
-				log.Println("DISCONNECT ->", ip)
-			}
-		case ip := <-server.httpOut:
-			server.online--
-			if server.debug {
-				log.Println("DISCONNECT ->", ip)
-			}
-		case s := <-sig:
-			return true, s, nil
-		case err := <-e:
-			return false, prevSig, err
-		}
+	if errors.As(e, &detail) {
+		return detail, true
 	}
-	return done, prevSig, nil
-}
-
-// IsZrpcError 是否為套件的錯誤型態
-func IsZrpcError(e error) (detail *ErrorDetail, yes bool) {
-	err := json.Unmarshal([]byte(e.Error()), &detail)
-	yes = err == nil
-	return
+	var value ErrorDetail
+	if errors.As(e, &value) {
+		return &value, true
+	}
+	return nil, false
 }
 
 // NewZrpcError 建立ZRPC的錯誤
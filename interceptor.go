@@ -0,0 +1,68 @@
+package zrpc
+
+import "context"
+
+// Handler 執行一次呼叫，req為方法的參數，回傳方法的結果
+type Handler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// Interceptor 伺服端攔截器，呼叫next繼續執行，不呼叫則直接回應
+type Interceptor func(ctx context.Context, info *CallInfo, req interface{}, next Handler) (interface{}, error)
+
+// Invoker 送出一次呼叫，結果寫入reply
+type Invoker func(ctx context.Context, args interface{}, reply interface{}) error
+
+// ClientInterceptor 客戶端攔截器，呼叫next送出請求，不呼叫則直接返回
+type ClientInterceptor func(ctx context.Context, info *CallInfo, args interface{}, reply interface{}, next Invoker) error
+
+// CallInfo 呼叫的資訊
+type CallInfo struct {
+	ServiceMethod string            // 如 "arith.Sum"
+	Transport     string            // "rpc"、"jsonrpc"，伺服端收到的HTTP呼叫為 "http"
+	RemoteAddr    string            // 伺服端為呼叫端位址，客戶端為服務位址
+	Service       string            // Proxy轉送時的服務名稱
+	Metadata      map[string]string // 客戶端攔截器設定後隨RPC與JSON-RPC請求送出，伺服端攔截器可讀取
+}
+
+// Use 加入伺服端攔截器，先加入的在外層，套用到RPC、JSON-RPC與HTTP的呼叫
+// 包含以address轉送到其他伺服器的HTTP呼叫
+// 需在開始服務前設定
+func (server *Server) Use(interceptors ...Interceptor) *Server {
+	server.interceptors = append(server.interceptors, interceptors...)
+	return server
+}
+
+// intercept 以攔截器包裝handler
+func (server *Server) intercept(info *CallInfo, handler Handler) Handler {
+	for i := len(server.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := server.interceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, info, req, next)
+		}
+	}
+	return handler
+}
+
+// Use 加入客戶端攔截器，先加入的在外層，套用到Call、CallContext與Go
+// 攔截器在重試之外，每次呼叫只執行一次；需在開始呼叫前設定
+func (client *Client) Use(interceptors ...ClientInterceptor) *Client {
+	client.interceptors = append(client.interceptors, interceptors...)
+	return client
+}
+
+// intercept 以攔截器包裝invoker
+func (client *Client) intercept(info *CallInfo, invoker Invoker) Invoker {
+	for i := len(client.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := client.interceptors[i], invoker
+		invoker = func(ctx context.Context, args interface{}, reply interface{}) error {
+			return interceptor(ctx, info, args, reply, next)
+		}
+	}
+	return invoker
+}
+
+// Use 加入轉送時的客戶端攔截器，每次轉送到端點(含重試)都會執行，info.Service為服務名稱
+// 需在開始服務前設定
+func (proxy *Proxy) Use(interceptors ...ClientInterceptor) *Proxy {
+	proxy.client.Use(interceptors...)
+	return proxy
+}
@@ -0,0 +1,87 @@
+package zrpc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// metadataRecorder 記錄伺服端收到的metadata，沒有token時拒絕呼叫
+type metadataRecorder struct {
+	mx   sync.Mutex
+	seen []map[string]string
+}
+
+func (m *metadataRecorder) intercept(ctx context.Context, info *CallInfo, req interface{}, next Handler) (interface{}, error) {
+	m.mx.Lock()
+	m.seen = append(m.seen, info.Metadata)
+	m.mx.Unlock()
+	if info.Transport != "http" && info.Metadata["token"] != "secret" {
+		return nil, Unauthenticated("invalid token", nil)
+	}
+	return next(ctx, req)
+}
+
+func (m *metadataRecorder) last() map[string]string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if len(m.seen) == 0 {
+		return nil
+	}
+	return m.seen[len(m.seen)-1]
+}
+
+// setToken 設定metadata的客戶端攔截器
+func setToken(token string) ClientInterceptor {
+	return func(ctx context.Context, info *CallInfo, args interface{}, reply interface{}, next Invoker) error {
+		info.Metadata = map[string]string{"token": token, "method": info.ServiceMethod}
+		return next(ctx, args, reply)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	recorder := new(metadataRecorder)
+	server := startTestServer(t, recorder.intercept)
+
+	for _, kind := range rpcKinds {
+		var reply int
+		client := testClient(t, server, kind)
+		err := client.Call("arith.Sum", &ArithArgs{A: 1, B: 2}, &reply)
+		if !IsCode(err, CodeUnauthenticated) {
+			t.Errorf("%s without metadata: %v", kind, err)
+		}
+		if md := recorder.last(); md != nil {
+			t.Errorf("%s without metadata: server got %v", kind, md)
+		}
+
+		client.Use(setToken("secret"))
+		if err := client.Call("arith.Sum", &ArithArgs{A: 1, B: 2}, &reply); err != nil || reply != 3 {
+			t.Fatalf("%s with metadata: %d %v", kind, reply, err)
+		}
+		if md := recorder.last(); md["token"] != "secret" || md["method"] != "arith.Sum" {
+			t.Errorf("%s: server got metadata %v", kind, md)
+		}
+	}
+}
+
+func TestMetadataThroughProxy(t *testing.T) {
+	recorder := new(metadataRecorder)
+	backend := startTestServer(t, recorder.intercept)
+
+	proxy := NewProxy().AddService("Arith", backend.JSONRPCNet.Addr().String(), "", WithRPCName("arith"))
+	proxy.PrefixPath = "/"
+	proxy.Use(setToken("secret"))
+	defer proxy.client.Close()
+
+	body := `{"jsonrpc":"2.0","id":1,"service":"Arith","method":"Sum","params":{"A":1,"B":2}}`
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"result":3`) {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	if md := recorder.last(); md["token"] != "secret" || md["method"] != "arith.Sum" {
+		t.Errorf("backend got metadata %v", md)
+	}
+}
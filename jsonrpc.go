@@ -0,0 +1,419 @@
+package zrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 標準錯誤代碼
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// Request JSON-RPC 2.0 請求，service 與 address 為擴充欄位
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Service string          `json:"service,omitempty"`
+	Address string          `json:"address,omitempty"`
+}
+
+// Response JSON-RPC 2.0 回應
+type Response struct {
+	Result interface{}
+	Error  *RPCError
+	ID     json.RawMessage
+}
+
+// MarshalJSON 成功時只輸出result，失敗時只輸出error
+func (res Response) MarshalJSON() ([]byte, error) {
+	id := res.ID
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	if res.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			Error   *RPCError       `json:"error"`
+			ID      json.RawMessage `json:"id"`
+		}{"2.0", res.Error, id})
+	}
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result"`
+		ID      json.RawMessage `json:"id"`
+	}{"2.0", res.Result, id})
+}
+
+// RPCError JSON-RPC 2.0 錯誤物件
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error 顯示錯誤訊息
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// callFunc 執行一次呼叫
+type callFunc func(ctx context.Context, data *Input) (interface{}, error)
+
+// 批次請求預設同時執行的呼叫數
+const defaultBatchLimit = 8
+
+// gateway HTTP閘道的設定
+type gateway struct {
+	legacy     bool // 也接受舊格式的請求
+	batchLimit int  // 批次請求最多同時執行的呼叫數
+	alwaysOK   bool // 錯誤時也回應200
+}
+
+// serve 讀取請求並回應，請求為JSON-RPC 2.0，開啟legacy時也接受舊格式
+// 請求為陣列時視為批次，最多同時執行batchLimit個呼叫
+func (g gateway) serve(w http.ResponseWriter, r *http.Request, call callFunc) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Println("[ZRPC] Read Request Error ->", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' && json.Valid(body) {
+		g.serveBatch(w, r, body, call)
+		return
+	}
+
+	res, status, notify := g.handle(r, body, call)
+	if notify {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if g.alwaysOK {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, res)
+}
+
+// serveBatch 同時處理批次中的請求，依原順序回應，全部為通知時不回應內容
+// 個別呼叫的錯誤放在各自的回應中，整體一律為200
+func (g gateway) serveBatch(w http.ResponseWriter, r *http.Request, body []byte, call callFunc) {
+	var messages []json.RawMessage
+	json.Unmarshal(body, &messages)
+	if len(messages) == 0 {
+		status := http.StatusBadRequest
+		if g.alwaysOK {
+			status = http.StatusOK
+		}
+		writeJSON(w, status, Response{Error: &RPCError{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "empty batch"}})
+		return
+	}
+	limit := g.batchLimit
+	if limit <= 0 {
+		limit = defaultBatchLimit
+	}
+
+	var (
+		results = make([]interface{}, len(messages))
+		notify  = make([]bool, len(messages))
+		sem     = make(chan struct{}, limit)
+		wg      sync.WaitGroup
+	)
+	for i, msg := range messages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, msg json.RawMessage) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i], _, notify[i] = g.handle(r, msg, call)
+		}(i, msg)
+	}
+	wg.Wait()
+
+	responses := []interface{}{}
+	for i, res := range results {
+		if !notify[i] {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, responses)
+}
+
+// handle 處理單一請求，沒有jsonrpc欄位且開啟legacy時使用舊格式
+func (g gateway) handle(r *http.Request, body []byte, call callFunc) (res interface{}, status int, notify bool) {
+	if g.legacy {
+		var envelope struct {
+			JSONRPC *string `json:"jsonrpc"`
+		}
+		if json.Unmarshal(body, &envelope) != nil || envelope.JSONRPC == nil {
+			res, status = handleLegacy(r, body, call)
+			return
+		}
+	}
+	return handleRequest(r, body, call)
+}
+
+// handleRequest 處理一個JSON-RPC 2.0請求，通知時不需回應
+func handleRequest(r *http.Request, body []byte, call callFunc) (res Response, status int, notify bool) {
+	status = http.StatusBadRequest
+	if !json.Valid(body) {
+		res.Error = &RPCError{Code: CodeParseError, Message: "Parse error"}
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		res.Error = &RPCError{Code: CodeInvalidRequest, Message: "Invalid Request", Data: err.Error()}
+		return
+	}
+	if !validID(req.ID) {
+		res.Error = &RPCError{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "invalid id"}
+		return
+	}
+	res.ID = req.ID
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		res.Error = &RPCError{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "jsonrpc must be \"2.0\" and method is required"}
+		return
+	}
+	notify = req.ID == nil
+
+	params, err := decodeParams(req.Params)
+	if err != nil {
+		res.Error = toRPCError(err)
+		return
+	}
+
+	ctx, cancel, err := requestContext(r)
+	if err != nil {
+		res.Error = &RPCError{Code: CodeInvalidRequest, Message: "Invalid Timeout", Data: r.Header.Get(TimeoutHeader)}
+		return
+	}
+	defer cancel()
+
+	result, err := call(ctx, &Input{
+		Service: req.Service,
+		Method:  req.Method,
+		Params:  params,
+		Address: req.Address,
+	})
+	if err != nil {
+		res.Error = toRPCError(err)
+		status = httpStatus(err)
+		return
+	}
+	res.Result = result
+	status = http.StatusOK
+	return
+}
+
+// handleLegacy 以舊格式處理請求
+func handleLegacy(r *http.Request, body []byte, call callFunc) (Output, int) {
+	var data Input
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		return Output{
+			Result: nil,
+			Error: ErrorDetail{
+				Code:    CodeInternal,
+				Message: err.Error(),
+				Data:    err,
+			},
+			ID: data.ID,
+		}, http.StatusBadRequest
+	}
+
+	ctx, cancel, err := requestContext(r)
+	if err != nil {
+		return Output{
+			Result: nil,
+			Error: ErrorDetail{
+				Code:    CodeInvalidArgument,
+				Message: "Invalid Timeout",
+				Data:    r.Header.Get(TimeoutHeader),
+			},
+			ID: data.ID,
+		}, http.StatusBadRequest
+	}
+	defer cancel()
+
+	res, err := call(ctx, &data)
+	if err != nil {
+		output := Output{
+			Result: nil,
+			Error:  nil,
+			ID:     data.ID,
+		}
+
+		// 舊格式的代碼與HTTP狀態碼一致
+		status := httpStatus(err)
+		var rpcErr *RPCError
+		if errors.As(err, &rpcErr) {
+			output.Error = ErrorDetail{
+				Code:    strconv.Itoa(status),
+				Message: rpcErr.Message,
+				Data:    rpcErr.Data,
+			}
+		} else if jsonrpcErr, yes := IsZrpcError(err); yes {
+			output.Error = jsonrpcErr
+		} else {
+			log.Println("[ZRPC] JSON DECODE Error ->", err)
+			output.Error = ErrorDetail{
+				Code:    strconv.Itoa(status),
+				Message: err.Error(),
+				Data:    err,
+			}
+		}
+		return output, status
+	}
+
+	return Output{
+		Result: res,
+		Error:  nil,
+		ID:     data.ID,
+	}, http.StatusOK
+}
+
+// validID id只能是字串、數字或null，未提供表示通知
+func validID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	switch id[0] {
+	case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
+}
+
+// decodeParams 解析參數，陣列依位置對應唯一的參數，物件直接傳入
+func decodeParams(raw json.RawMessage) (interface{}, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	switch raw[0] {
+	case '{':
+		var params map[string]interface{}
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+		}
+		return params, nil
+	case '[':
+		var params []interface{}
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: err.Error()}
+		}
+		if len(params) != 1 {
+			return nil, &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: "positional params must have exactly one element"}
+		}
+		return params[0], nil
+	}
+	return nil, &RPCError{Code: CodeInvalidRequest, Message: "Invalid Request", Data: "params must be an object or an array"}
+}
+
+// toRPCError 將錯誤轉為JSON-RPC 2.0錯誤物件，ZRPC錯誤的數字代碼會保留
+func toRPCError(err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	if detail, ok := IsZrpcError(err); ok {
+		code, e := strconv.Atoi(detail.Code)
+		if e != nil {
+			return &RPCError{Code: CodeServerError, Message: detail.Message, Data: detail}
+		}
+		return &RPCError{Code: code, Message: detail.Message, Data: detail.Data}
+	}
+
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		msg := string(serverErr)
+		switch {
+		case strings.HasPrefix(msg, "rpc: can't find"), strings.HasPrefix(msg, "rpc: service/method request ill-formed"):
+			return &RPCError{Code: CodeMethodNotFound, Message: "Method not found", Data: msg}
+		case invalidParams(msg):
+			return &RPCError{Code: CodeInvalidParams, Message: "Invalid params", Data: msg}
+		}
+		return &RPCError{Code: CodeServerError, Message: msg}
+	}
+
+	// 連線失敗或逾時，代碼與HTTP狀態碼同樣由CodeOf決定
+	log.Println("[ZRPC] Call Error ->", err)
+	status := StatusOf(err)
+	return &RPCError{Code: status, Message: http.StatusText(status), Data: err.Error()}
+}
+
+// invalidParams 伺服端的錯誤是否為參數錯誤
+func invalidParams(msg string) bool {
+	return strings.HasPrefix(msg, "json: ") || msg == errMissingParams.Error()
+}
+
+// httpStatus 呼叫錯誤對應的HTTP狀態碼
+func httpStatus(err error) int {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case CodeParseError, CodeInvalidRequest, CodeInvalidParams:
+			return http.StatusBadRequest
+		case CodeMethodNotFound:
+			return http.StatusNotFound
+		}
+		return http.StatusInternalServerError
+	}
+	// ZRPC錯誤也會解開為rpc.ServerError，需先依代碼決定
+	if _, ok := IsZrpcError(err); ok {
+		return StatusOf(err)
+	}
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		switch toRPCError(serverErr).Code {
+		case CodeMethodNotFound:
+			return http.StatusNotFound
+		case CodeInvalidParams:
+			return http.StatusBadRequest
+		}
+		return http.StatusInternalServerError
+	}
+
+	// 逾時、取消與無法連線，與回應中的代碼同樣由CodeOf決定
+	return StatusOf(err)
+}
+
+// writeJSON 以指定的狀態碼輸出JSON回應
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println("[ZRPC] Response Error ->", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		b, _ = json.Marshal(Response{Error: &RPCError{Code: CodeInternalError, Message: "Internal error", Data: err.Error()}})
+		w.Write(b)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(append(b, '\n'))
+}
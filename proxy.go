@@ -1,43 +1,101 @@
 package zrpc
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
+	"strconv"
 	"time"
 )
 
 // Proxy 代理伺服
 type Proxy struct {
-	PrefixPath string
-	Services   map[string]Service
-	HTTPAddr   string
-	HTTPNet    net.Listener
-	HTTPServer *http.Server
-	timeout    int64
-	ui         bool
-	debug      bool
-	mx         *sync.RWMutex
+	PrefixPath     string
+	Services       *Registry
+	HTTPAddr       string
+	HTTPNet        net.Listener
+	HTTPServer     *http.Server
+	client         *Client
+	configFile     string
+	configWatch    time.Duration
+	reloadOnHUP    bool
+	configServices map[string]bool
+	resolver       Resolver
+	dnsInterval    time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	healthInterval time.Duration
+	healthTimeout  time.Duration
+	ui             bool
+	debug          bool
+	gateway        gateway
+	lifecycle      lifecycle
 }
 
 // NewProxy 建立一個伺服器
 func NewProxy() (p *Proxy) {
 	p = &Proxy{
-		Services: map[string]Service{},
-		mx:       new(sync.RWMutex),
+		Services:    NewRegistry(),
+		client:      NewClient(""),
+		configWatch: defaultConfigWatch,
+		resolver:    net.DefaultResolver,
+		dnsInterval: defaultDNSInterval,
+		lifecycle:   newLifecycle(),
 	}
 	p.SetHTTPAddress(os.Getenv("ZRPC_PROXY_ADDRESS"))
 	p.EnableWebUI(os.Getenv("ZRPC_ENABLE_UI") == "true")
 	p.DebugMode(os.Getenv("ZRPC_DEBUG_MODE") == "true")
+	p.EnableLegacy(os.Getenv("ZRPC_LEGACY_ENVELOPE") == "true")
+	p.AlwaysOK(os.Getenv("ZRPC_HTTP_ALWAYS_OK") == "true")
+
+	// 檢查批次請求同時執行數環境變數
+	if st := os.Getenv("ZRPC_BATCH_LIMIT"); st != "" {
+		if n, err := strconv.Atoi(st); err == nil {
+			p.SetBatchLimit(n)
+		}
+	}
+	p.SetConfigFile(os.Getenv("ZRPC_PROXY_CONFIG"))
+
+	// 檢查DNS解析間隔環境變數
+	if st := os.Getenv("ZRPC_DNS_INTERVAL"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			p.SetDNSInterval(time.Duration(t) * time.Second)
+		}
+	}
+
+	// 檢查設定檔檢查間隔環境變數
+	if st := os.Getenv("ZRPC_CONFIG_WATCH_INTERVAL"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			p.SetConfigWatch(time.Duration(t) * time.Second)
+		}
+	}
+
+	// 檢查關閉等待時間環境變數
+	if st := os.Getenv("ZRPC_SHUTDOWN_TIMEOUT"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			p.SetShutdownTimeout(time.Duration(t) * time.Second)
+		}
+	}
+
+	// 檢查健康檢查環境變數
+	if st := os.Getenv("ZRPC_HEALTH_CHECK_INTERVAL"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			p.SetHealthCheck(time.Duration(t)*time.Second, 0)
+		}
+	}
 	return
 }
 
 // Init 初始化
 func (proxy *Proxy) Init() error {
+	// 載入設定檔
+	if proxy.configFile != "" {
+		if err := proxy.LoadConfig(proxy.configFile); err != nil {
+			return err
+		}
+	}
 	if proxy.PrefixPath == "" {
 		proxy.PrefixPath = "/rpc"
 	}
@@ -52,15 +110,15 @@ func (proxy *Proxy) Init() error {
 	if proxy.HTTPServer == nil {
 		proxy.HTTPServer = &http.Server{
 			Handler:      proxy,
-			WriteTimeout: time.Duration(proxy.timeout),
-			ReadTimeout:  time.Duration(proxy.timeout),
+			WriteTimeout: proxy.writeTimeout,
+			ReadTimeout:  proxy.readTimeout,
 		}
 	}
 	return nil
 }
 
-// AddService 新增服務
-func (proxy *Proxy) AddService(name, rpcAddr, httpAddr string) *Proxy {
+// AddService 新增服務，已存在的服務會改用新的位址
+func (proxy *Proxy) AddService(name, rpcAddr, httpAddr string, opts ...ServiceOption) *Proxy {
 	if proxy.debug {
 		log.Println("[ZRPC] =============================")
 		log.Println("[ZRPC] 註冊新服務 ->", name)
@@ -68,20 +126,90 @@ func (proxy *Proxy) AddService(name, rpcAddr, httpAddr string) *Proxy {
 		log.Println("[ZRPC] HTTP 服務位址 ->", httpAddr)
 		log.Println("[ZRPC] =============================")
 	}
-	proxy.mx.Lock()
-	defer proxy.mx.Unlock()
-	service, ok := proxy.Services[name]
-	if ok {
+	proxy.Services.update(func(tx *registryTx) {
+		service, ok := tx.get(name)
+		if !ok {
+			service = Service{
+				Name:     name,
+				Balancer: NewRoundRobinBalancer(),
+			}
+		}
+		service.Endpoints = []*Endpoint{{
+			RPCAddress:  rpcAddr,
+			HTTPAddress: httpAddr,
+		}}
+		service.DNS = ""
 		service.RPCAddress = rpcAddr
 		service.HTTPAddress = httpAddr
-	} else {
-		service = Service{
-			Name:        name,
+		for _, opt := range opts {
+			opt(&service)
+		}
+		service.setupBreakers()
+		tx.put(service)
+	})
+	return proxy
+}
+
+// AddEndpoint 為服務增加一個後端位址，服務不存在時會建立
+func (proxy *Proxy) AddEndpoint(name, rpcAddr, httpAddr string, opts ...ServiceOption) *Proxy {
+	if proxy.debug {
+		log.Println("[ZRPC] =============================")
+		log.Println("[ZRPC] 服務新增位址 ->", name)
+		log.Println("[ZRPC] TCP 服務位址 ->", rpcAddr)
+		log.Println("[ZRPC] HTTP 服務位址 ->", httpAddr)
+		log.Println("[ZRPC] =============================")
+	}
+	proxy.Services.update(func(tx *registryTx) {
+		service, ok := tx.get(name)
+		if !ok {
+			service = Service{
+				Name:        name,
+				RPCAddress:  rpcAddr,
+				HTTPAddress: httpAddr,
+				Balancer:    NewRoundRobinBalancer(),
+			}
+		}
+
+		// 同樣的位址以新的設定取代
+		endpoints := []*Endpoint{}
+		for _, ep := range service.Endpoints {
+			if ep.RPCAddress != rpcAddr {
+				endpoints = append(endpoints, ep)
+			}
+		}
+		service.Endpoints = append(endpoints, &Endpoint{
 			RPCAddress:  rpcAddr,
 			HTTPAddress: httpAddr,
+		})
+		service.DNS = ""
+		for _, opt := range opts {
+			opt(&service)
+		}
+		service.setupBreakers()
+		tx.put(service)
+	})
+	return proxy
+}
+
+// RemoveService 移除服務，處理中的請求結束後關閉不再使用的連線
+func (proxy *Proxy) RemoveService(name string) *Proxy {
+	if proxy.debug {
+		log.Println("[ZRPC] 移除服務 ->", name)
+	}
+	var old, services map[string]Service
+	proxy.Services.update(func(tx *registryTx) {
+		service, ok := tx.get(name)
+		if !ok {
+			return
 		}
+		tx.remove(name)
+		delete(proxy.configServices, name)
+		old = map[string]Service{name: service}
+		services = tx.services
+	})
+	if old != nil {
+		go proxy.drainEndpoints(removedEndpoints(old, services))
 	}
-	proxy.Services[name] = service
 	return proxy
 }
 
@@ -96,6 +224,24 @@ func (proxy *Proxy) DebugMode(debug bool) *Proxy {
 	return proxy
 }
 
+// EnableLegacy 除了JSON-RPC 2.0，也接受沒有jsonrpc欄位的舊格式請求，並以舊格式回應
+func (proxy *Proxy) EnableLegacy(enable bool) *Proxy {
+	proxy.gateway.legacy = enable
+	return proxy
+}
+
+// AlwaysOK 錯誤時也以HTTP 200回應，預設依錯誤回應對應的狀態碼
+func (proxy *Proxy) AlwaysOK(enable bool) *Proxy {
+	proxy.gateway.alwaysOK = enable
+	return proxy
+}
+
+// SetBatchLimit 設定批次請求最多同時執行的呼叫數，預設為8
+func (proxy *Proxy) SetBatchLimit(n int) *Proxy {
+	proxy.gateway.batchLimit = n
+	return proxy
+}
+
 // SetHTTPNet 設定HTTP網路
 func (proxy *Proxy) SetHTTPNet(n net.Listener) *Proxy {
 	proxy.HTTPNet = n
@@ -127,7 +273,19 @@ func (proxy *Proxy) GetHTTPAddress() string {
 
 // SetTimeout 設定連線逾時秒數
 func (proxy *Proxy) SetTimeout(second int64) *Proxy {
-	proxy.timeout = second
+	d := time.Duration(second) * time.Second
+	return proxy.SetReadTimeout(d).SetWriteTimeout(d)
+}
+
+// SetReadTimeout 設定讀取HTTP請求的逾時
+func (proxy *Proxy) SetReadTimeout(d time.Duration) *Proxy {
+	proxy.readTimeout = d
+	return proxy
+}
+
+// SetWriteTimeout 設定寫出HTTP回應的逾時
+func (proxy *Proxy) SetWriteTimeout(d time.Duration) *Proxy {
+	proxy.writeTimeout = d
 	return proxy
 }
 
@@ -148,37 +306,49 @@ func (proxy *Proxy) SetPrefixPath(path string) *Proxy {
 	return proxy
 }
 
-// Listen 監聽服務
+// Listen 監聽服務，收到SIGINT或SIGTERM時等待處理中的請求完成後關閉，收到SIGHUP時重新載入設定檔
+// 同一程序中有多個伺服器，或要自行處理訊號時，請改用Serve
 func (proxy *Proxy) Listen() error {
+	proxy.reloadOnHUP = true
+	ctx, cancel := SignalContext(context.Background())
+	defer cancel()
+	return proxy.Serve(ctx)
+}
+
+// Serve 監聽服務，ctx取消時等待處理中的請求完成後關閉，也可以呼叫Shutdown關閉
+func (proxy *Proxy) Serve(ctx context.Context) error {
 	// 檢查連線設定
-	if err := proxy.Init(); err != nil {
+	if ok, err := proxy.start(); !ok || err != nil {
 		return err
 	}
 
-	// 設置關閉機制
-	var (
-		err error
-		sig = make(chan os.Signal)
-		c   = make(chan int)
-	)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
+	result := make(chan error, 1)
+	go proxy.healthCheck(proxy.lifecycle.closing)
+	go proxy.watchConfig(proxy.lifecycle.closing)
+	go proxy.discover(proxy.lifecycle.closing)
+
 	go func() {
-		s := <-sig
-		log.Printf("[ZRPC] ... Receive signal, shutdown by ... %v", s)
-		close(c)
-		proxy.HTTPServer.Close()
+		select {
+		case <-ctx.Done():
+			ctx, cancel := context.WithTimeout(context.Background(), proxy.lifecycle.timeout)
+			defer cancel()
+			result <- proxy.Shutdown(ctx)
+		case <-proxy.lifecycle.closing:
+			<-proxy.lifecycle.done
+			result <- nil
+		}
 	}()
 
 	log.Println("[ZRPC] HTTP Server Listening ... ", proxy.HTTPNet.Addr().Network(), proxy.HTTPNet.Addr().String())
-	err = proxy.HTTPServer.Serve(proxy.HTTPNet)
-	if err != nil {
-		select {
-		case <-c:
-			return nil
-		default:
-			log.Printf("[ZRPC] ... Listen get error ... %s", err.Error())
-			return err
-		}
+	err := proxy.HTTPServer.Serve(proxy.HTTPNet)
+	if proxy.lifecycle.closed() {
+		// 等待處理中的請求完成
+		return <-result
 	}
-	return nil
+	log.Printf("[ZRPC] ... Listen get error ... %s", err.Error())
+	proxy.lifecycle.close()
+	proxy.HTTPServer.Close()
+	proxy.client.Close()
+	proxy.lifecycle.finish()
+	return err
 }
@@ -0,0 +1,230 @@
+package zrpc
+
+import (
+	"sort"
+	"sync"
+)
+
+// RegistryEventType 服務表變更的種類
+type RegistryEventType string
+
+// 服務表變更的種類
+const (
+	ServiceAdded   RegistryEventType = "added"
+	ServiceUpdated RegistryEventType = "updated"
+	ServiceRemoved RegistryEventType = "removed"
+	// RegistryLagged 訂閱者來不及讀取，之前有事件被丟棄，應以List重新取得服務表
+	RegistryLagged RegistryEventType = "lagged"
+)
+
+// 訂閱者的事件緩衝數
+const subscriberBuffer = 64
+
+// RegistryEvent 服務表變更，移除時Service為移除前的設定
+type RegistryEvent struct {
+	Type    RegistryEventType
+	Name    string
+	Service Service
+}
+
+// Registry 服務註冊表，可同時讀寫
+type Registry struct {
+	mx          sync.RWMutex
+	services    map[string]Service
+	subscribers map[int]*subscriber
+	nextID      int
+	sendMx      sync.Mutex // 確保事件依更新順序送出，送出不會阻塞
+}
+
+// subscriber 服務表變更的訂閱者
+type subscriber struct {
+	mx     sync.Mutex
+	ch     chan RegistryEvent
+	lagged bool
+	closed bool
+}
+
+// registryTx 一次更新中的服務表
+type registryTx struct {
+	old      map[string]Service
+	services map[string]Service
+	events   []RegistryEvent
+}
+
+// NewRegistry 建立服務註冊表
+func NewRegistry() *Registry {
+	return &Registry{
+		services:    map[string]Service{},
+		subscribers: map[int]*subscriber{},
+	}
+}
+
+// Add 新增服務，已存在的同名服務會被取代
+// 未設定Endpoints時以RPCAddress與HTTPAddress建立端點，未設定Balancer時使用輪詢
+func (r *Registry) Add(service Service) {
+	if len(service.Endpoints) == 0 && service.RPCAddress != "" {
+		service.Endpoints = []*Endpoint{{
+			RPCAddress:  service.RPCAddress,
+			HTTPAddress: service.HTTPAddress,
+		}}
+	} else {
+		service.Endpoints = append([]*Endpoint{}, service.Endpoints...)
+	}
+	if service.RPCAddress == "" && len(service.Endpoints) > 0 {
+		service.RPCAddress = service.Endpoints[0].RPCAddress
+		service.HTTPAddress = service.Endpoints[0].HTTPAddress
+	}
+	if service.Balancer == nil {
+		service.Balancer = NewRoundRobinBalancer()
+	}
+	service.setupBreakers()
+	r.update(func(tx *registryTx) {
+		tx.put(service)
+	})
+}
+
+// Remove 移除服務，回傳服務是否存在
+func (r *Registry) Remove(name string) (removed bool) {
+	r.update(func(tx *registryTx) {
+		removed = tx.remove(name)
+	})
+	return
+}
+
+// Get 取得服務
+func (r *Registry) Get(name string) (Service, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	service, ok := r.services[name]
+	return service, ok
+}
+
+// List 依名稱排序列出所有服務
+func (r *Registry) List() []Service {
+	services := r.table()
+	list := make([]Service, 0, len(services))
+	for _, service := range services {
+		list = append(list, service)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Subscribe 訂閱服務表變更，呼叫cancel取消訂閱
+// 送出事件不會等待訂閱者，緩衝已滿時丟棄事件，之後先送出RegistryLagged
+func (r *Registry) Subscribe() (events <-chan RegistryEvent, cancel func()) {
+	sub := &subscriber{ch: make(chan RegistryEvent, subscriberBuffer)}
+	r.mx.Lock()
+	id := r.nextID
+	r.nextID++
+	r.subscribers[id] = sub
+	r.mx.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			r.mx.Lock()
+			delete(r.subscribers, id)
+			r.mx.Unlock()
+			sub.close()
+		})
+	}
+}
+
+// send 不阻塞地送出事件，緩衝已滿時丟棄並標記落後
+func (sub *subscriber) send(event RegistryEvent) {
+	sub.mx.Lock()
+	defer sub.mx.Unlock()
+	if sub.closed {
+		return
+	}
+	if sub.lagged {
+		select {
+		case sub.ch <- RegistryEvent{Type: RegistryLagged}:
+			sub.lagged = false
+		default:
+			return
+		}
+	}
+	select {
+	case sub.ch <- event:
+	default:
+		sub.lagged = true
+	}
+}
+
+// close 關閉訂閱者的通道
+func (sub *subscriber) close() {
+	sub.mx.Lock()
+	defer sub.mx.Unlock()
+	sub.closed = true
+	close(sub.ch)
+}
+
+// table 取得目前的服務表，回傳的map不會再被修改，呼叫端也不可修改
+func (r *Registry) table() map[string]Service {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return r.services
+}
+
+// update 在鎖內以複本修改服務表，完成後一次替換並通知訂閱者
+func (r *Registry) update(fn func(tx *registryTx)) {
+	r.mx.Lock()
+	tx := &registryTx{
+		old:      r.services,
+		services: make(map[string]Service, len(r.services)),
+	}
+	for name, service := range r.services {
+		tx.services[name] = service
+	}
+	fn(tx)
+	r.services = tx.services
+	if len(tx.events) == 0 {
+		r.mx.Unlock()
+		return
+	}
+	subscribers := make([]*subscriber, 0, len(r.subscribers))
+	for _, sub := range r.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+
+	// 先取得送出的鎖再釋放服務表的鎖，確保事件依更新順序送出
+	r.sendMx.Lock()
+	r.mx.Unlock()
+	defer r.sendMx.Unlock()
+	for _, event := range tx.events {
+		for _, sub := range subscribers {
+			sub.send(event)
+		}
+	}
+}
+
+// get 取得更新中的服務
+func (tx *registryTx) get(name string) (Service, bool) {
+	service, ok := tx.services[name]
+	return service, ok
+}
+
+// put 新增或取代服務
+func (tx *registryTx) put(service Service) {
+	event := ServiceAdded
+	if _, ok := tx.services[service.Name]; ok {
+		event = ServiceUpdated
+	}
+	tx.services[service.Name] = service
+	tx.events = append(tx.events, RegistryEvent{Type: event, Name: service.Name, Service: service})
+}
+
+// remove 移除服務，回傳服務是否存在
+func (tx *registryTx) remove(name string) bool {
+	service, ok := tx.services[name]
+	if !ok {
+		return false
+	}
+	delete(tx.services, name)
+	tx.events = append(tx.events, RegistryEvent{Type: ServiceRemoved, Name: name, Service: service})
+	return true
+}
@@ -0,0 +1,192 @@
+package zrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	events, cancel := r.Subscribe()
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range events {
+			n++
+		}
+		received <- n
+	}()
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				name := fmt.Sprintf("svc-%d-%d", w, i%10)
+				r.Add(Service{Name: name, RPCAddress: "127.0.0.1:50051"})
+				for _, service := range r.List() {
+					if service.Name == "" {
+						t.Error("listed service without name")
+					}
+				}
+				if _, ok := r.Get(name); !ok {
+					t.Errorf("service %s not found after Add", name)
+				}
+				r.Remove(name)
+			}
+		}(w)
+	}
+	wg.Wait()
+	cancel()
+
+	if n := <-received; n == 0 {
+		t.Fatal("subscriber received no events")
+	}
+	if list := r.List(); len(list) != 0 {
+		t.Fatalf("registry not empty: %d services", len(list))
+	}
+}
+
+func TestRegistrySlowSubscriber(t *testing.T) {
+	r := NewRegistry()
+	slow, cancelSlow := r.Subscribe()
+	defer cancelSlow()
+	fast, cancelFast := r.Subscribe()
+
+	got := make(chan []RegistryEvent)
+	go func() {
+		list := []RegistryEvent{}
+		for e := range fast {
+			list = append(list, e)
+		}
+		got <- list
+	}()
+
+	// 慢的訂閱者不讀取時，更新不可被阻塞
+	const updates = subscriberBuffer * 3
+	done := make(chan int)
+	go func() {
+		for i := 0; i < updates; i++ {
+			r.Add(Service{Name: fmt.Sprintf("svc-%d", i), RPCAddress: "127.0.0.1:50051"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updates blocked by a slow subscriber")
+	}
+
+	for i := 0; i < subscriberBuffer; i++ {
+		e := <-slow
+		if e.Type != ServiceAdded || e.Name != fmt.Sprintf("svc-%d", i) {
+			t.Fatalf("event %d: %s %s", i, e.Type, e.Name)
+		}
+	}
+	select {
+	case e := <-slow:
+		t.Fatalf("unexpected event after full buffer: %s %s", e.Type, e.Name)
+	default:
+	}
+
+	// 有空間後先收到落後通知，再收到新的事件
+	r.Remove("svc-0")
+	if e := <-slow; e.Type != RegistryLagged {
+		t.Fatalf("expected lagged event, got %s", e.Type)
+	}
+	if e := <-slow; e.Type != ServiceRemoved || e.Name != "svc-0" {
+		t.Fatalf("expected removed svc-0, got %s %s", e.Type, e.Name)
+	}
+
+	// 取消訂閱不需等待送出
+	cancelFast()
+	cancelFast()
+	if list := <-got; len(list) == 0 {
+		t.Fatal("fast subscriber received no events")
+	}
+}
+
+func TestRegistryCancelDuringUpdates(t *testing.T) {
+	r := NewRegistry()
+	stop := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			r.Add(Service{Name: fmt.Sprintf("svc-%d", i%5), RPCAddress: "127.0.0.1:50051"})
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		_, cancel := r.Subscribe()
+		cancel()
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestRegistryAddSetup(t *testing.T) {
+	r := NewRegistry()
+	r.Add(Service{Name: "Arith", RPCAddress: "127.0.0.1:50051", HTTPAddress: "127.0.0.1:8080"})
+
+	service, ok := r.Get("Arith")
+	if !ok {
+		t.Fatal("service not found")
+	}
+	if service.Balancer == nil {
+		t.Fatal("default balancer not set")
+	}
+	ep := service.pick(nil)
+	if ep == nil {
+		t.Fatal("no available endpoint")
+	}
+	if ep.RPCAddress != "127.0.0.1:50051" || ep.HTTPAddress != "127.0.0.1:8080" {
+		t.Fatalf("endpoint %s %s", ep.RPCAddress, ep.HTTPAddress)
+	}
+
+	// 只給端點時以第一個端點為服務位址，並建立斷路器
+	config := BreakerConfig{}
+	r.Add(Service{
+		Name:      "Echo",
+		Endpoints: []*Endpoint{{RPCAddress: "127.0.0.1:50061"}, {RPCAddress: "127.0.0.1:50062"}},
+		breaker:   &config,
+	})
+	service, _ = r.Get("Echo")
+	if service.RPCAddress != "127.0.0.1:50061" {
+		t.Fatalf("rpc address %s", service.RPCAddress)
+	}
+	for _, ep := range service.Endpoints {
+		if ep.Breaker == nil {
+			t.Fatalf("endpoint %s has no breaker", ep.RPCAddress)
+		}
+	}
+}
+
+func TestRegistryEndpoint(t *testing.T) {
+	proxy := NewProxy().AddService("Arith", "127.0.0.1:50051", "127.0.0.1:8080")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("GET", "/registry", nil))
+
+	var body struct {
+		Services []Service `json:"services"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %s: %s", w.Body.String(), err)
+	}
+	if w.Code != 200 || len(body.Services) != 1 || body.Services[0].Name != "Arith" {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+}
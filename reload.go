@@ -0,0 +1,275 @@
+package zrpc
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 檢查設定檔變更的預設間隔
+const defaultConfigWatch = 2 * time.Second
+
+// 等待已移除端點的請求結束的上限
+const drainTimeout = 30 * time.Second
+
+// SetConfigWatch 設定檢查設定檔變更的間隔，為0則不檢查
+func (proxy *Proxy) SetConfigWatch(interval time.Duration) *Proxy {
+	proxy.configWatch = interval
+	return proxy
+}
+
+// ReloadOnSIGHUP 收到SIGHUP時重新載入設定檔，Listen會自動開啟，使用Serve時預設不處理訊號
+func (proxy *Proxy) ReloadOnSIGHUP(enable bool) *Proxy {
+	proxy.reloadOnHUP = enable
+	return proxy
+}
+
+// ReloadConfig 重新讀取設定檔並替換其中的服務，失敗時保留目前的服務
+func (proxy *Proxy) ReloadConfig() error {
+	if proxy.configFile == "" {
+		return fmt.Errorf("config file is not set")
+	}
+	config, err := LoadProxyConfig(proxy.configFile)
+	if err != nil {
+		return err
+	}
+
+	old, services := proxy.replaceConfigServices(config.Services)
+	logServiceDiff(old, services)
+	go proxy.drainEndpoints(removedEndpoints(old, services))
+	proxy.refreshAllDNS()
+	return nil
+}
+
+// replaceConfigServices 以新的設定取代先前由設定檔載入的服務，回傳替換前後的服務表
+func (proxy *Proxy) replaceConfigServices(configs []ServiceConfig) (old, services map[string]Service) {
+	// 整張服務表一次替換，處理中的請求仍使用舊的服務設定
+	proxy.Services.update(func(tx *registryTx) {
+		old = tx.old
+		loaded := map[string]bool{}
+		for _, sc := range configs {
+			service := sc.service()
+			if prev, ok := old[sc.Name]; ok {
+				if service.DNS != "" && service.DNS == prev.DNS {
+					// 沿用已解析的端點，待下次解析再更新
+					service.RPCAddress = prev.RPCAddress
+					for _, ep := range prev.Endpoints {
+						service.Endpoints = append(service.Endpoints, &Endpoint{RPCAddress: ep.RPCAddress})
+					}
+					service.setupBreakers()
+				}
+				reuseEndpoints(prev, &service)
+			}
+			tx.put(service)
+			loaded[sc.Name] = true
+		}
+		for name := range proxy.configServices {
+			if !loaded[name] {
+				tx.remove(name)
+			}
+		}
+		proxy.configServices = loaded
+		services = tx.services
+	})
+	return
+}
+
+// watchConfig 設定檔變更或收到SIGHUP(有開啟時)時重新載入，直到stop關閉
+func (proxy *Proxy) watchConfig(stop chan int) {
+	if proxy.configFile == "" {
+		return
+	}
+
+	var hup chan os.Signal
+	if proxy.reloadOnHUP {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	var tick <-chan time.Time
+	if proxy.configWatch > 0 {
+		ticker := time.NewTicker(proxy.configWatch)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	modTime, size := fileStamp(proxy.configFile)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			log.Println("[ZRPC] ... Receive SIGHUP, reload config ...", proxy.configFile)
+		case <-tick:
+			t, s := fileStamp(proxy.configFile)
+			if t.Equal(modTime) && s == size {
+				continue
+			}
+			modTime, size = t, s
+			log.Println("[ZRPC] ... Config changed, reload config ...", proxy.configFile)
+		}
+
+		if err := proxy.ReloadConfig(); err != nil {
+			log.Println("[ZRPC] Reload Config Error, keep current services ->", err)
+		}
+	}
+}
+
+// drainEndpoints 等待已移除端點的請求結束後關閉其連線
+func (proxy *Proxy) drainEndpoints(endpoints []*Endpoint) {
+	deadline := time.Now().Add(drainTimeout)
+	for _, ep := range endpoints {
+		for ep.Pending() > 0 && time.Now().Before(deadline) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		if n := ep.Pending(); n > 0 {
+			log.Printf("[ZRPC] Endpoint %s still has %d pending calls, close anyway", ep.RPCAddress, n)
+		}
+		proxy.client.closeAddress(ep.RPCAddress)
+	}
+}
+
+// fileStamp 取得檔案的修改時間與大小
+func fileStamp(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return info.ModTime(), info.Size()
+}
+
+// reuseEndpoints 位址不變的端點沿用舊的端點，保留健康狀態、斷路器與處理中的請求數
+// 斷路器設定變更時改用新的端點，只沿用健康狀態
+func reuseEndpoints(prev Service, service *Service) {
+	sameBreaker := reflect.DeepEqual(prev.breaker, service.breaker)
+	for i, ep := range service.Endpoints {
+		for _, old := range prev.Endpoints {
+			if old.RPCAddress != ep.RPCAddress {
+				continue
+			}
+			if sameBreaker && old.HTTPAddress == ep.HTTPAddress {
+				service.Endpoints[i] = old
+			} else {
+				ep.setHealthy(old.Healthy())
+			}
+			break
+		}
+	}
+}
+
+// removedEndpoints 新服務表中不再使用的端點
+func removedEndpoints(old, services map[string]Service) []*Endpoint {
+	used := map[string]bool{}
+	for _, service := range services {
+		for _, ep := range service.Endpoints {
+			used[ep.RPCAddress] = true
+		}
+	}
+	removed := []*Endpoint{}
+	for _, service := range old {
+		for _, ep := range service.Endpoints {
+			if !used[ep.RPCAddress] {
+				removed = append(removed, ep)
+			}
+		}
+	}
+	return removed
+}
+
+// logServiceDiff 輸出服務表的變更
+func logServiceDiff(old, services map[string]Service) {
+	names := []string{}
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range services {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, name := range names {
+		prev, hadPrev := old[name]
+		service, ok := services[name]
+		switch {
+		case !ok:
+			log.Printf("[ZRPC] Reload: service (%s) removed", name)
+		case !hadPrev:
+			addresses := []string{}
+			for _, ep := range service.Endpoints {
+				addresses = append(addresses, ep.RPCAddress)
+			}
+			log.Printf("[ZRPC] Reload: service (%s) added -> %s", name, strings.Join(addresses, ", "))
+		default:
+			endpoints := diffEndpoints(name, prev, service)
+			settings := !sameSettings(prev, service)
+			if settings {
+				log.Printf("[ZRPC] Reload: service (%s) settings updated", name)
+			}
+			if !endpoints && !settings {
+				continue
+			}
+		}
+		changed = true
+	}
+	if !changed {
+		log.Println("[ZRPC] Reload: no service changed")
+	}
+}
+
+// diffEndpoints 輸出服務端點的增減，回傳是否有變更
+func diffEndpoints(name string, prev, service Service) bool {
+	addresses := func(s Service) map[string]string {
+		m := map[string]string{}
+		for _, ep := range s.Endpoints {
+			m[ep.RPCAddress] = ep.HTTPAddress
+		}
+		return m
+	}
+	before, after := addresses(prev), addresses(service)
+
+	changed := false
+	for _, ep := range service.Endpoints {
+		if http, ok := before[ep.RPCAddress]; !ok {
+			log.Printf("[ZRPC] Reload: service (%s) endpoint %s added", name, ep.RPCAddress)
+			changed = true
+		} else if http != ep.HTTPAddress {
+			log.Printf("[ZRPC] Reload: service (%s) endpoint %s http address %q -> %q", name, ep.RPCAddress, http, ep.HTTPAddress)
+			changed = true
+		}
+	}
+	for _, ep := range prev.Endpoints {
+		if _, ok := after[ep.RPCAddress]; !ok {
+			log.Printf("[ZRPC] Reload: service (%s) endpoint %s removed", name, ep.RPCAddress)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// sameSettings 服務的DNS、逾時、負載平衡、斷路器與重試設定是否相同
+func sameSettings(prev, service Service) bool {
+	return prev.DNS == service.DNS &&
+		prev.RPCName == service.RPCName &&
+		prev.Timeout == service.Timeout &&
+		balancerKind(prev.Balancer) == balancerKind(service.Balancer) &&
+		reflect.DeepEqual(prev.breaker, service.breaker) &&
+		reflect.DeepEqual(prev.Retry, service.Retry)
+}
+
+// balancerKind 負載平衡策略的種類，雜湊策略包含欄位名稱
+func balancerKind(b Balancer) string {
+	if h, ok := b.(hashBalancer); ok {
+		return "hash:" + h.field
+	}
+	return fmt.Sprintf("%T", b)
+}
@@ -0,0 +1,61 @@
+package zrpc
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	// 測試程序自己也接收SIGHUP，避免未處理時結束程序
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	path := filepath.Join(t.TempDir(), "zrpc.json")
+	write := func(name string) {
+		body := `{"services":[{"name":"` + name + `","rpc_address":"127.0.0.1:50051"}]}`
+		if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloaded := func(enable bool) bool {
+		t.Helper()
+		write("Arith")
+		proxy := NewProxy().SetConfigFile(path).SetConfigWatch(0).ReloadOnSIGHUP(enable)
+		if err := proxy.LoadConfig(path); err != nil {
+			t.Fatal(err)
+		}
+		defer proxy.client.Close()
+		stop := make(chan int)
+		defer close(stop)
+		go proxy.watchConfig(stop)
+		time.Sleep(50 * time.Millisecond)
+
+		write("Echo")
+		syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		<-hup
+		for i := 0; i < 20; i++ {
+			if _, ok := proxy.Services.Get("Echo"); ok {
+				if _, ok := proxy.Services.Get("Arith"); ok {
+					t.Fatal("service removed from the file is still registered")
+				}
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	// Serve預設不處理SIGHUP
+	if reloaded(false) {
+		t.Fatal("reloaded on SIGHUP without ReloadOnSIGHUP")
+	}
+	if !reloaded(true) {
+		t.Fatal("not reloaded on SIGHUP with ReloadOnSIGHUP")
+	}
+}
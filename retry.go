@@ -0,0 +1,108 @@
+package zrpc
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryPolicy 重試策略，只有列在Methods中的冪等方法才會重試
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多嘗試次數(含第一次)
+	InitialBackoff time.Duration // 第一次重試前的等待時間
+	MaxBackoff     time.Duration // 等待時間上限
+	Multiplier     float64       // 每次重試等待時間的倍數
+	Jitter         float64       // 等待時間隨機減少的比例(0~1)
+	RetryOn        []string      // 可重試的錯誤代碼
+	Methods        []string      // 冪等方法，如 "arith.Sum"、"arith.*"、"*"
+}
+
+// DefaultRetryPolicy 預設的重試策略，未設定Methods所以不會重試任何方法
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+	RetryOn:        []string{CodeUnavailable},
+}
+
+// normalize 補上未設定欄位的預設值
+func (p RetryPolicy) normalize() *RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	if len(p.RetryOn) == 0 {
+		p.RetryOn = DefaultRetryPolicy.RetryOn
+	}
+	return &p
+}
+
+// idempotent 方法是否標記為冪等
+func (p *RetryPolicy) idempotent(serviceMethod string) bool {
+	for _, m := range p.Methods {
+		if m == "*" || m == serviceMethod {
+			return true
+		}
+		if strings.HasSuffix(m, ".*") && strings.HasPrefix(serviceMethod, strings.TrimSuffix(m, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable 錯誤是否可以重試
+func (p *RetryPolicy) retryable(err error) bool {
+	code := CodeOf(err)
+	for _, c := range p.RetryOn {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 第attempt次重試前要等待的時間
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d -= rand.Float64() * p.Jitter * d
+	return time.Duration(d)
+}
+
+// retry 依重試策略執行fn，非冪等方法只會執行一次
+func retry(ctx context.Context, policy *RetryPolicy, serviceMethod string, fn func() error) error {
+	if policy == nil || !policy.idempotent(serviceMethod) {
+		return fn()
+	}
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt+1 >= policy.MaxAttempts || !policy.retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -1,36 +1,41 @@
 package zrpc
 
 import (
+	"context"
+	"io"
 	"log"
 	"net"
 	"net/http"
-	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
-	"os/signal"
 	"strconv"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Server 伺服端
 type Server struct {
-	RPCAddr     string
-	RPCNet      net.Listener
-	JSONRPCAddr string
-	JSONRPCNet  net.Listener
-	HTTPAddr    string
-	HTTPNet     net.Listener
-	HTTPServer  *http.Server
-	Services    []Service
-	kind        string
-	timeout     int64
-	debug       bool
-	online      int
-	rpcIn       chan string
-	rpcOut      chan string
-	httpIn      chan string
-	httpOut     chan string
+	RPCAddr      string
+	RPCNet       net.Listener
+	JSONRPCAddr  string
+	JSONRPCNet   net.Listener
+	HTTPAddr     string
+	HTTPNet      net.Listener
+	HTTPServer   *http.Server
+	Services     []Service
+	services     map[string]*rpcService
+	servicesMx   sync.RWMutex
+	interceptors []Interceptor
+	panics       atomic.Int64
+	client       *Client
+	kind         string
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	debug        bool
+	gateway      gateway
+	lifecycle    lifecycle
+	tracker      tracker
 }
 
 // NewServer 建立一個伺服器
@@ -42,25 +47,31 @@ func NewServer() *Server {
 		httpAddr = os.Getenv("ZRPC_HTTP_ADDRESS")
 	)
 
-	if rpcKind == "rpc" {
+	switch rpcKind {
+	case "rpc":
 		server = &Server{
-			kind:     rpcKind,
-			RPCAddr:  rpcAddr,
-			HTTPAddr: httpAddr,
-			rpcIn:    make(chan string),
-			rpcOut:   make(chan string),
-			httpIn:   make(chan string),
-			httpOut:  make(chan string),
+			kind:      rpcKind,
+			RPCAddr:   rpcAddr,
+			HTTPAddr:  httpAddr,
+			client:    NewClient(""),
+			lifecycle: newLifecycle(),
 		}
-	} else {
+	case "both":
+		server = &Server{
+			kind:        rpcKind,
+			RPCAddr:     rpcAddr,
+			JSONRPCAddr: os.Getenv("ZRPC_JSONRPC_ADDRESS"),
+			HTTPAddr:    httpAddr,
+			client:      NewClient(""),
+			lifecycle:   newLifecycle(),
+		}
+	default:
 		server = &Server{
 			kind:        "jsonrpc",
 			JSONRPCAddr: rpcAddr,
 			HTTPAddr:    httpAddr,
-			rpcIn:       make(chan string),
-			rpcOut:      make(chan string),
-			httpIn:      make(chan string),
-			httpOut:     make(chan string),
+			client:      NewClient(""),
+			lifecycle:   newLifecycle(),
 		}
 	}
 
@@ -73,15 +84,39 @@ func NewServer() *Server {
 			server.SetTimeout(int64(t))
 		}
 	}
+	if st := os.Getenv("ZRPC_READ_TIMEOUT"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			server.SetReadTimeout(time.Duration(t) * time.Second)
+		}
+	}
+	if st := os.Getenv("ZRPC_WRITE_TIMEOUT"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			server.SetWriteTimeout(time.Duration(t) * time.Second)
+		}
+	}
+	if st := os.Getenv("ZRPC_SHUTDOWN_TIMEOUT"); st != "" {
+		if t, err := strconv.Atoi(st); err == nil {
+			server.SetShutdownTimeout(time.Duration(t) * time.Second)
+		}
+	}
 
 	// 檢查除錯模式
 	server.DebugMode(os.Getenv("ZRPC_DEBUG_MODE") == "true")
+	server.EnableLegacy(os.Getenv("ZRPC_LEGACY_ENVELOPE") == "true")
+	server.AlwaysOK(os.Getenv("ZRPC_HTTP_ALWAYS_OK") == "true")
+
+	// 檢查批次請求同時執行數環境變數
+	if st := os.Getenv("ZRPC_BATCH_LIMIT"); st != "" {
+		if n, err := strconv.Atoi(st); err == nil {
+			server.SetBatchLimit(n)
+		}
+	}
 	return server
 }
 
 // Init 初始化
 func (server *Server) Init() error {
-	if server.kind == "rpc" {
+	if server.servesRPC() {
 		if server.RPCNet == nil || server.RPCNet.Addr().Network() == "" {
 			l, e := net.Listen("tcp", server.GetRPCAddress())
 			if e != nil {
@@ -89,7 +124,8 @@ func (server *Server) Init() error {
 			}
 			server.RPCNet = l
 		}
-	} else {
+	}
+	if server.servesJSONRPC() {
 		if server.JSONRPCNet == nil || server.JSONRPCNet.Addr().Network() == "" {
 			l, e := net.Listen("tcp", server.GetJSONRPCAddress())
 			if e != nil {
@@ -127,9 +163,27 @@ func (server *Server) DebugMode(debug bool) *Server {
 	return server
 }
 
-// SetServer 設定伺服器
+// EnableLegacy 除了JSON-RPC 2.0，也接受沒有jsonrpc欄位的舊格式請求，並以舊格式回應
+func (server *Server) EnableLegacy(enable bool) *Server {
+	server.gateway.legacy = enable
+	return server
+}
+
+// AlwaysOK 錯誤時也以HTTP 200回應，預設依錯誤回應對應的狀態碼
+func (server *Server) AlwaysOK(enable bool) *Server {
+	server.gateway.alwaysOK = enable
+	return server
+}
+
+// SetBatchLimit 設定批次請求最多同時執行的呼叫數，預設為8
+func (server *Server) SetBatchLimit(n int) *Server {
+	server.gateway.batchLimit = n
+	return server
+}
+
+// SetServer 設定伺服器，"rpc"為gob編碼、"jsonrpc"為JSON編碼、"both"同時提供兩者
 func (server *Server) SetServer(s string) *Server {
-	if s == "rpc" || s == "jsonrpc" {
+	if s == "rpc" || s == "jsonrpc" || s == "both" {
 		server.kind = s
 	} else {
 		panic("server is wrong")
@@ -193,6 +247,12 @@ func (server *Server) GetRPCAddress() string {
 // GetJSONRPCAddress 取JSONRPC的連線網址
 func (server *Server) GetJSONRPCAddress() string {
 	if server.JSONRPCAddr == "" {
+		if server.kind == "both" {
+			if addr := os.Getenv("ZRPC_JSONRPC_ADDRESS"); addr != "" {
+				return addr
+			}
+			return ":50052"
+		}
 		if addr := os.Getenv("ZRPC_SERVER_ADDRESS"); addr != "" {
 			return addr
 		}
@@ -212,15 +272,32 @@ func (server *Server) GetHTTPAddress() string {
 	return server.HTTPAddr
 }
 
-// SetTimeout 設定連線逾時秒數
+// SetTimeout 設定連線閒置逾時秒數
 func (server *Server) SetTimeout(second int64) *Server {
-	server.timeout = second
+	return server.SetIdleTimeout(time.Duration(second) * time.Second)
+}
+
+// SetIdleTimeout 設定連線閒置逾時，每次收到請求或送出回應都會重新計算
+func (server *Server) SetIdleTimeout(d time.Duration) *Server {
+	server.idleTimeout = d
+	return server
+}
+
+// SetReadTimeout 設定讀取單一請求的逾時
+func (server *Server) SetReadTimeout(d time.Duration) *Server {
+	server.readTimeout = d
+	return server
+}
+
+// SetWriteTimeout 設定寫出單一回應的逾時
+func (server *Server) SetWriteTimeout(d time.Duration) *Server {
+	server.writeTimeout = d
 	return server
 }
 
 // Register 註冊服務
 func (server *Server) Register(service interface{}) error {
-	err := rpc.Register(service)
+	err := server.register(service, "", false)
 	if err != nil {
 		if server.debug {
 			log.Println("[ZRPC] =============================")
@@ -247,7 +324,7 @@ func (server *Server) Register(service interface{}) error {
 
 // RegisterName 註冊服務
 func (server *Server) RegisterName(name string, service interface{}) error {
-	err := rpc.RegisterName(name, service)
+	err := server.register(service, name, true)
 	if err != nil {
 		if server.debug {
 			log.Println("[ZRPC] =============================")
@@ -273,148 +350,105 @@ func (server *Server) RegisterName(name string, service interface{}) error {
 	return nil
 }
 
-// Listen 監聽連線
+// Listen 監聽連線，收到SIGINT或SIGTERM時等待處理中的請求完成後關閉
+// 同一程序中有多個伺服器，或要自行處理訊號時，請改用Serve
 func (server *Server) Listen() error {
+	ctx, cancel := SignalContext(context.Background())
+	defer cancel()
+	return server.Serve(ctx)
+}
+
+// Serve 監聽連線，ctx取消時等待處理中的請求完成後關閉，也可以呼叫Shutdown關閉
+func (server *Server) Serve(ctx context.Context) error {
 	// 檢查連線設定
-	if err := server.Init(); err != nil {
+	if ok, err := server.start(); !ok || err != nil {
 		return err
 	}
-
-	// 設置關閉機制
-	var (
-		err     error
-		sig     = make(chan os.Signal)
-		prevSig os.Signal
-		c       = make(chan int)
-		e       = make(chan error)
-		done    bool
-	)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
+	e := make(chan error, 3)
 
 	// RPC
-	go func() {
-		switch server.kind {
-		case "rpc":
-			// RPC
-			log.Println("[ZRPC] RPC Server Listening ... ", server.RPCNet.Addr().Network(), server.RPCNet.Addr().String())
-			go func() {
-				for {
-					conn, err := server.RPCNet.Accept()
-					if err != nil {
-						select {
-						case <-c:
-							return
-						default:
-							log.Println("[ZRPC] Error: accept rpc connection ->", err)
-							e <- err
-						}
-						continue
-					}
-					if server.debug {
-						log.Printf("[ZRPC] Accept rpc connection from %s", conn.RemoteAddr())
-					}
-					// 設定連線timeout
-					if server.timeout > 0 {
-						conn.SetDeadline(time.Now().Add(time.Second * time.Duration(server.timeout)))
-					}
-					go func() {
-						ip := conn.RemoteAddr().String()
-						server.rpcIn <- ip
-						jsonrpc.ServeConn(conn)
-						server.rpcOut <- ip
-					}()
-				}
-			}()
-			break
-		case "jsonrpc":
-			// JSON-RPC
-			log.Println("[ZRPC] JSON-RPC Server Listening ... ", server.JSONRPCNet.Addr().Network(), server.JSONRPCNet.Addr().String())
-			go func() {
-				for {
-					conn, err := server.JSONRPCNet.Accept()
-					if err != nil {
-						select {
-						case <-c:
-							return
-						default:
-							log.Println("[ZRPC] Error: accept jsonrpc connection ->", err)
-							e <- err
-						}
-						continue
-					}
-
-					if server.debug {
-						log.Printf("[ZRPC] Accept jsonrpc connection from %s", conn.RemoteAddr())
-					}
-
-					// 設定連線timeout
-					if server.timeout > 0 {
-						conn.SetDeadline(time.Now().Add(time.Second * time.Duration(server.timeout)))
-					}
-					go func(conn net.Conn) {
-						ip := conn.RemoteAddr().String()
-						server.rpcIn <- ip
-						jsonrpc.ServeConn(conn)
-						server.rpcOut <- ip
-					}(conn)
-				}
-			}()
-			break
-		}
-	}()
+	if server.servesRPC() {
+		log.Println("[ZRPC] RPC Server Listening ... ", server.RPCNet.Addr().Network(), server.RPCNet.Addr().String())
+		go server.accept(server.RPCNet, "rpc", server.serveRPC, e)
+	}
+
+	// JSON-RPC
+	if server.servesJSONRPC() {
+		log.Println("[ZRPC] JSON-RPC Server Listening ... ", server.JSONRPCNet.Addr().Network(), server.JSONRPCNet.Addr().String())
+		go server.accept(server.JSONRPCNet, "jsonrpc", server.serveJSONRPC, e)
+	}
 
 	// HTTP
 	go func() {
 		log.Println("[ZRPC] HTTP Server Listening ... ", server.HTTPNet.Addr().Network(), server.HTTPNet.Addr().String())
 		err := server.HTTPServer.Serve(server.HTTPNet)
-		if err != nil {
-			select {
-			case <-c:
-				return
-			default:
-				log.Println("Error: accept http connection ->", err)
-				e <- err
-			}
+		if err != nil && !server.lifecycle.closed() {
+			log.Println("Error: accept http connection ->", err)
+			e <- err
 		}
 	}()
 
+	select {
+	case <-ctx.Done():
+		ctx, cancel := context.WithTimeout(context.Background(), server.lifecycle.timeout)
+		defer cancel()
+		return server.Shutdown(ctx)
+	case <-server.lifecycle.closing:
+		<-server.lifecycle.done
+		return nil
+	case err := <-e:
+		log.Printf("[ZRPC] ... Listen get error ... %s", err.Error())
+		server.forceClose()
+		return err
+	}
+}
+
+// accept 接受連線，並交給serveConn處理
+func (server *Server) accept(l net.Listener, kind string, serveConn func(io.ReadWriteCloser), e chan error) {
 	for {
-		prevSig, done = server.delectSignal(done, sig, prevSig)
-		if done {
-			log.Printf("[ZRPC] ... Receive signal, shutdown by ... %v", prevSig)
-			close(c)
-			if server.RPCNet != nil {
-				server.RPCNet.Close()
-			}
-			if server.JSONRPCNet != nil {
-				server.JSONRPCNet.Close()
-			}
-			for {
-				if server.online <= 0 {
-					break
-				}
-				done, prevSig, err = server.waitConnection(done, sig, prevSig, e)
-			}
-			if server.HTTPServer != nil {
-				server.HTTPServer.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			if !server.lifecycle.closed() {
+				log.Printf("[ZRPC] Error: accept %s connection -> %s", kind, err)
+				e <- err
 			}
-			return nil
+			return
 		}
 
-		done, prevSig, err = server.waitConnection(done, sig, prevSig, e)
-		if err != nil {
-			log.Printf("[ZRPC] ... Listen get error ... %s", err.Error())
-			close(c)
-			if server.RPCNet != nil {
-				server.RPCNet.Close()
-			}
-			if server.JSONRPCNet != nil {
-				server.JSONRPCNet.Close()
-			}
-			if server.HTTPServer != nil {
-				server.HTTPServer.Close()
-			}
-			return err
+		if server.debug {
+			log.Printf("[ZRPC] Accept %s connection from %s", kind, conn.RemoteAddr())
 		}
+		if !server.tracker.addConn(conn) {
+			conn.Close()
+			continue
+		}
+
+		go func(conn net.Conn) {
+			defer server.tracker.removeConn(conn)
+			serveConn(conn)
+			if server.debug {
+				log.Printf("[ZRPC] Close %s connection from %s", kind, conn.RemoteAddr())
+			}
+		}(conn)
 	}
 }
+
+// servesRPC 是否提供gob編碼的RPC服務
+func (server *Server) servesRPC() bool {
+	return server.kind == "rpc" || server.kind == "both"
+}
+
+// servesJSONRPC 是否提供JSON-RPC服務
+func (server *Server) servesJSONRPC() bool {
+	return server.kind == "jsonrpc" || server.kind == "both"
+}
+
+// serveRPC 以gob編碼服務連線
+func (server *Server) serveRPC(conn io.ReadWriteCloser) {
+	server.serveCodec(conn, "rpc", newGobServerCodec)
+}
+
+// serveJSONRPC 以JSON-RPC編碼服務連線
+func (server *Server) serveJSONRPC(conn io.ReadWriteCloser) {
+	server.serveCodec(conn, "jsonrpc", newJSONServerCodec)
+}
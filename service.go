@@ -6,14 +6,169 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Service 服務
 type Service struct {
 	Name        string            `json:"name,omitempty"`
+	RPCName     string            `json:"rpc_name,omitempty"`
 	Methods     map[string]string `json:"methods,omitempty"`
 	RPCAddress  string            `json:"rpc_address,omitempty"`
 	HTTPAddress string            `json:"http_address,omitempty"`
+	Endpoints   []*Endpoint       `json:"endpoints,omitempty"`
+	DNS         string            `json:"dns,omitempty"`
+	Timeout     time.Duration     `json:"timeout,omitempty"`
+	Balancer    Balancer          `json:"-"`
+	Breaker     *Breaker          `json:"breaker,omitempty"`
+	Retry       *RetryPolicy      `json:"-"`
+	breaker     *BreakerConfig
+}
+
+// Endpoint 服務的其中一個後端位址
+type Endpoint struct {
+	RPCAddress  string   `json:"rpc_address"`
+	HTTPAddress string   `json:"http_address,omitempty"`
+	Breaker     *Breaker `json:"-"`
+	pending     atomic.Int64
+	down        int32
+}
+
+// Pending 處理中的請求數
+func (ep *Endpoint) Pending() int64 {
+	return ep.pending.Load()
+}
+
+// Healthy 最近一次健康檢查是否正常，未檢查過視為正常
+func (ep *Endpoint) Healthy() bool {
+	return atomic.LoadInt32(&ep.down) == 0
+}
+
+// setHealthy 更新健康狀態，回傳狀態是否有改變
+func (ep *Endpoint) setHealthy(healthy bool) bool {
+	var down int32
+	if !healthy {
+		down = 1
+	}
+	return atomic.SwapInt32(&ep.down, down) != down
+}
+
+// MarshalJSON 輸出端點與目前的狀態
+func (ep *Endpoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RPCAddress  string   `json:"rpc_address"`
+		HTTPAddress string   `json:"http_address,omitempty"`
+		Healthy     bool     `json:"healthy"`
+		Pending     int64    `json:"pending"`
+		Breaker     *Breaker `json:"breaker,omitempty"`
+	}{
+		RPCAddress:  ep.RPCAddress,
+		HTTPAddress: ep.HTTPAddress,
+		Healthy:     ep.Healthy(),
+		Pending:     ep.Pending(),
+		Breaker:     ep.Breaker,
+	})
+}
+
+// pick 以服務的負載平衡策略挑選健康且未斷路的端點，略過exclude中的端點
+func (s Service) pick(data *Input, exclude ...*Endpoint) *Endpoint {
+	endpoints := []*Endpoint{}
+	for _, ep := range s.Endpoints {
+		if ep.Healthy() && ep.Breaker.Ready() && !containsEndpoint(exclude, ep) {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	if s.Balancer == nil {
+		if len(endpoints) == 0 {
+			return nil
+		}
+		return endpoints[0]
+	}
+	return s.Balancer.Pick(endpoints, data)
+}
+
+// httpEndpoint 取得有HTTP位址的端點供顯示，優先選擇健康的端點，不經過負載平衡
+func (s Service) httpEndpoint() *Endpoint {
+	var picked *Endpoint
+	for _, ep := range s.Endpoints {
+		if ep.HTTPAddress == "" {
+			continue
+		}
+		if ep.Healthy() {
+			return ep
+		}
+		if picked == nil {
+			picked = ep
+		}
+	}
+	return picked
+}
+
+// containsEndpoint 端點是否在清單中
+func containsEndpoint(endpoints []*Endpoint, ep *Endpoint) bool {
+	for _, e := range endpoints {
+		if e == ep {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcMethod 將呼叫的方法轉為後端註冊的 "Service.Method"
+// 有設定RPCName時，以對外服務名稱開頭的方法會改用RPCName
+func (s Service) rpcMethod(method string) string {
+	if s.RPCName == "" {
+		return composeMethod(s.Name, method)
+	}
+	return composeMethod(s.RPCName, strings.TrimPrefix(method, s.Name+"."))
+}
+
+// composeMethod 組合服務與方法名稱，method已含服務名稱時直接使用
+func composeMethod(service, method string) string {
+	if service == "" || strings.Contains(method, ".") {
+		return method
+	}
+	return service + "." + method
+}
+
+// ServiceOption 服務設定
+type ServiceOption func(*Service)
+
+// WithBalancer 設定服務的負載平衡策略，預設為輪詢
+func WithBalancer(b Balancer) ServiceOption {
+	return func(s *Service) {
+		s.Balancer = b
+	}
+}
+
+// WithBreaker 為服務及其每個端點設定斷路器
+func WithBreaker(config BreakerConfig) ServiceOption {
+	return func(s *Service) {
+		s.breaker = &config
+		s.Breaker = NewBreaker(config)
+	}
+}
+
+// WithRPCName 設定服務在後端註冊的名稱，對外仍使用AddService的名稱
+func WithRPCName(name string) ServiceOption {
+	return func(s *Service) {
+		s.RPCName = name
+	}
+}
+
+// WithTimeout 設定呼叫服務的逾時
+func WithTimeout(d time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.Timeout = d
+	}
+}
+
+// WithRetry 設定服務的重試策略，只有policy.Methods中的冪等方法會重試
+func WithRetry(policy RetryPolicy) ServiceOption {
+	return func(s *Service) {
+		s.Retry = policy.normalize()
+	}
 }
 
 // ReflectMethod 反映服務可用方法
@@ -30,6 +185,9 @@ func ReflectMethod(service interface{}) (name string, methods map[string]string)
 	return
 }
 
+// errorType error的型別
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
 func getService(addr string) ([]Service, error) {
 
 	url := "http://" + addr + "/services"
@@ -57,3 +215,15 @@ func getService(addr string) ([]Service, error) {
 
 	return srv, nil
 }
+
+// setupBreakers 為尚未設定斷路器的端點建立斷路器
+func (s *Service) setupBreakers() {
+	if s.breaker == nil {
+		return
+	}
+	for _, ep := range s.Endpoints {
+		if ep.Breaker == nil {
+			ep.Breaker = NewBreaker(*s.breaker)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package zrpc
+
+import "testing"
+
+func TestHTTPEndpoint(t *testing.T) {
+	dns := &Endpoint{RPCAddress: "10.0.0.1:50051"}
+	down := &Endpoint{RPCAddress: "10.0.0.2:50051", HTTPAddress: "10.0.0.2:8080"}
+	down.setHealthy(false)
+	up := &Endpoint{RPCAddress: "10.0.0.3:50051", HTTPAddress: "10.0.0.3:8080"}
+	balancer := NewRoundRobinBalancer()
+	service := Service{Name: "Arith", Endpoints: []*Endpoint{dns, down, up}, Balancer: balancer}
+
+	first := service.pick(nil)
+	if ep := service.httpEndpoint(); ep != up {
+		t.Fatalf("got %v, want the healthy endpoint with an HTTP address", ep)
+	}
+	// 取得顯示用的端點不影響輪詢順序
+	if second := service.pick(nil); second == first {
+		t.Fatal("round robin did not advance between picks")
+	}
+
+	up.setHealthy(false)
+	if ep := service.httpEndpoint(); ep != down {
+		t.Fatalf("got %v, want an unhealthy endpoint with an HTTP address", ep)
+	}
+	if ep := (Service{Endpoints: []*Endpoint{dns}}).httpEndpoint(); ep != nil {
+		t.Fatalf("got %v for endpoints without HTTP address", ep)
+	}
+}
@@ -0,0 +1,253 @@
+package zrpc
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// 收到訊號後等待處理中請求完成的預設時間
+const defaultShutdownTimeout = 30 * time.Second
+
+// 關閉時檢查處理中請求的間隔
+const shutdownPollInterval = 20 * time.Millisecond
+
+// lifecycle 伺服器的關閉狀態
+type lifecycle struct {
+	mx        sync.Mutex // 初始化與關閉不同時進行
+	closing   chan int   // 開始關閉時關閉
+	done      chan int   // 關閉完成時關閉
+	closeOnce sync.Once
+	doneOnce  sync.Once
+	timeout   time.Duration
+}
+
+func newLifecycle() lifecycle {
+	return lifecycle{
+		closing: make(chan int),
+		done:    make(chan int),
+		timeout: defaultShutdownTimeout,
+	}
+}
+
+// close 標記開始關閉，回傳是否為第一次呼叫
+func (l *lifecycle) close() (first bool) {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+		first = true
+	})
+	return
+}
+
+// closed 是否已開始關閉
+func (l *lifecycle) closed() bool {
+	select {
+	case <-l.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish 標記關閉完成
+func (l *lifecycle) finish() {
+	l.doneOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+// tracker 記錄處理中的呼叫與連線，關閉時用來等待呼叫結束並關閉閒置的連線
+type tracker struct {
+	mx      sync.Mutex
+	calls   int
+	conns   map[io.Closer]int // 連線與其處理中的呼叫數
+	closing bool
+}
+
+// addConn 記錄連線，已在關閉中則回傳false
+func (t *tracker) addConn(c io.Closer) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.closing {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = map[io.Closer]int{}
+	}
+	t.conns[c] = 0
+	return true
+}
+
+// removeConn 連線結束
+func (t *tracker) removeConn(c io.Closer) {
+	t.mx.Lock()
+	delete(t.conns, c)
+	t.mx.Unlock()
+}
+
+// begin 收到一個呼叫
+func (t *tracker) begin(c io.Closer) {
+	t.mx.Lock()
+	t.calls++
+	if n, ok := t.conns[c]; ok {
+		t.conns[c] = n + 1
+	}
+	t.mx.Unlock()
+}
+
+// end 呼叫已回應，關閉中且連線已無處理中的呼叫時關閉連線
+func (t *tracker) end(c io.Closer) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.calls--
+	n, ok := t.conns[c]
+	if !ok {
+		return
+	}
+	t.conns[c] = n - 1
+	if t.closing && n-1 <= 0 {
+		c.Close()
+	}
+}
+
+// closeIdle 開始關閉，並關閉沒有處理中呼叫的連線
+func (t *tracker) closeIdle() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.closing = true
+	for c, n := range t.conns {
+		if n <= 0 {
+			c.Close()
+		}
+	}
+}
+
+// closeAll 強制關閉所有連線
+func (t *tracker) closeAll() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.closing = true
+	for c := range t.conns {
+		c.Close()
+	}
+}
+
+// idle 是否已沒有處理中的呼叫
+func (t *tracker) idle() bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.calls <= 0
+}
+
+// start 初始化伺服器，已呼叫過Shutdown時回傳false
+func (server *Server) start() (bool, error) {
+	server.lifecycle.mx.Lock()
+	defer server.lifecycle.mx.Unlock()
+	if server.lifecycle.closed() {
+		return false, nil
+	}
+	return true, server.Init()
+}
+
+// SetShutdownTimeout 設定收到訊號後等待處理中請求完成的時間，預設30秒
+func (server *Server) SetShutdownTimeout(d time.Duration) *Server {
+	server.lifecycle.timeout = d
+	return server
+}
+
+// Shutdown 停止接受新連線，等待處理中的呼叫完成後關閉連線
+// ctx到期時強制關閉所有連線，並回傳ctx的錯誤
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.lifecycle.mx.Lock()
+	defer server.lifecycle.mx.Unlock()
+	if server.lifecycle.close() && server.debug {
+		log.Println("[ZRPC] Server shutting down ...")
+	}
+	defer server.lifecycle.finish()
+
+	if server.RPCNet != nil {
+		server.RPCNet.Close()
+	}
+	if server.JSONRPCNet != nil {
+		server.JSONRPCNet.Close()
+	}
+
+	// HTTP請求可能經由RPC連線轉送，先等HTTP請求完成
+	var err error
+	if server.HTTPServer != nil {
+		err = server.HTTPServer.Shutdown(ctx)
+	}
+
+	// 釋放HTTP轉送時保留的連線，避免等待自己的連線結束
+	server.client.Close()
+	server.tracker.closeIdle()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !server.tracker.idle() {
+		select {
+		case <-ctx.Done():
+			server.forceClose()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if err != nil {
+		server.forceClose()
+	}
+	return err
+}
+
+// forceClose 立即關閉所有監聽與連線
+func (server *Server) forceClose() {
+	server.lifecycle.close()
+	if server.RPCNet != nil {
+		server.RPCNet.Close()
+	}
+	if server.JSONRPCNet != nil {
+		server.JSONRPCNet.Close()
+	}
+	if server.HTTPServer != nil {
+		server.HTTPServer.Close()
+	}
+	server.client.Close()
+	server.tracker.closeAll()
+}
+
+// start 初始化代理伺服，已呼叫過Shutdown時回傳false
+func (proxy *Proxy) start() (bool, error) {
+	proxy.lifecycle.mx.Lock()
+	defer proxy.lifecycle.mx.Unlock()
+	if proxy.lifecycle.closed() {
+		return false, nil
+	}
+	return true, proxy.Init()
+}
+
+// SetShutdownTimeout 設定收到訊號後等待處理中請求完成的時間，預設30秒
+func (proxy *Proxy) SetShutdownTimeout(d time.Duration) *Proxy {
+	proxy.lifecycle.timeout = d
+	return proxy
+}
+
+// Shutdown 停止接受新請求，並停止健康檢查、設定檔與DNS的更新，等待處理中的請求完成後關閉
+// ctx到期時強制關閉所有連線，並回傳ctx的錯誤
+func (proxy *Proxy) Shutdown(ctx context.Context) error {
+	proxy.lifecycle.mx.Lock()
+	defer proxy.lifecycle.mx.Unlock()
+	if proxy.lifecycle.close() && proxy.debug {
+		log.Println("[ZRPC] Proxy shutting down ...")
+	}
+	defer proxy.lifecycle.finish()
+
+	var err error
+	if proxy.HTTPServer != nil {
+		if err = proxy.HTTPServer.Shutdown(ctx); err != nil {
+			proxy.HTTPServer.Close()
+		}
+	}
+	proxy.client.Close()
+	return err
+}
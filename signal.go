@@ -0,0 +1,30 @@
+package zrpc
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalContext 收到訊號時取消的context，未指定訊號時為SIGINT與SIGTERM
+// 同一程序中的Server與Proxy可共用同一個context，再各自呼叫Serve
+func SignalContext(parent context.Context, sigs ...os.Signal) (context.Context, context.CancelFunc) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	ctx, cancel := context.WithCancel(parent)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, sigs...)
+	go func() {
+		defer signal.Stop(sig)
+		select {
+		case s := <-sig:
+			log.Printf("[ZRPC] ... Receive signal, shutdown by ... %v", s)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -18,11 +18,29 @@ type Output struct {
 	ID     int         `json:"id"`
 }
 
-// ErrorDetail 錯誤細節
+// MarshalJSON 輸出結果，非ZRPC的錯誤轉為代碼500的ErrorDetail
+func (o Output) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Result interface{} `json:"result"`
+		Error  interface{} `json:"error"`
+		ID     int         `json:"id"`
+	}{o.Result, nil, o.ID}
+	if o.Error != nil {
+		detail, ok := IsZrpcError(o.Error)
+		if !ok {
+			detail = Internal(o.Error.Error(), nil)
+		}
+		out.Error = detail
+	}
+	return json.Marshal(out)
+}
+
+// ErrorDetail 錯誤細節，Error()輸出JSON以便經由RPC傳回後還原
 type ErrorDetail struct {
 	Code    string      `json:"code"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
+	cause   error
 }
 
 // Error 顯示ErrorDetail的訊息
@@ -33,3 +51,8 @@ func (e ErrorDetail) Error() string {
 	}
 	return string(errMsg)
 }
+
+// Unwrap 取得原始的錯誤，如客戶端收到的 rpc.ServerError
+func (e ErrorDetail) Unwrap() error {
+	return e.cause
+}
@@ -0,0 +1,381 @@
+package zrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 設定檔只需要TOML的一部分：key = value、[table]、[[array]]、陣列、inline table、字串、數字與布林
+// 多行字串與日期時間不支援，遇到時回傳錯誤
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlToJSON 將TOML設定檔轉為JSON
+func tomlToJSON(b []byte) ([]byte, error) {
+	v, err := parseTOML(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// parseTOML 將TOML解析為map、slice與純量
+func parseTOML(b []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	current := root
+	lines := strings.Split(string(b), "\n")
+	for i := 0; i < len(lines); i++ {
+		num := i + 1
+		text := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if text == "" {
+			continue
+		}
+		if strings.Contains(text, `"""`) || strings.Contains(text, "'''") {
+			return nil, fmt.Errorf("toml: line %d: multi-line strings are not supported", num)
+		}
+		// 陣列與inline table可以跨行，直到括號成對
+		for tomlDepth(text) > 0 && i+1 < len(lines) {
+			i++
+			text += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(text, "[["):
+			if !strings.HasSuffix(text, "]]") {
+				return nil, fmt.Errorf("toml: line %d: invalid table header %s", num, text)
+			}
+			current, err = tomlArrayTable(root, text[2:len(text)-2])
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("toml: line %d: invalid table header %s", num, text)
+			}
+			current, err = tomlTable(root, text[1:len(text)-1])
+		default:
+			err = tomlKeyValue(current, text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("toml: line %d: %s", num, err)
+		}
+	}
+	return root, nil
+}
+
+// tomlKeyValue 解析key = value並設定到table
+func tomlKeyValue(table map[string]interface{}, text string) error {
+	eq := tomlIndex(text, '=')
+	if eq < 0 {
+		return fmt.Errorf("expected \"key = value\", got %q", text)
+	}
+	keys, err := splitTOMLKey(text[:eq])
+	if err != nil {
+		return err
+	}
+	p := &tomlValue{s: strings.TrimSpace(text[eq+1:])}
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.i < len(p.s) {
+		return fmt.Errorf("unexpected %q after value", p.s[p.i:])
+	}
+	return tomlSet(table, keys, v)
+}
+
+// tomlTable 取得[a.b]指定的table，不存在時建立
+func tomlTable(root map[string]interface{}, header string) (map[string]interface{}, error) {
+	keys, err := splitTOMLKey(header)
+	if err != nil {
+		return nil, err
+	}
+	return tomlWalk(root, keys)
+}
+
+// tomlArrayTable 在[[a.b]]指定的陣列加入一個table
+func tomlArrayTable(root map[string]interface{}, header string) (map[string]interface{}, error) {
+	keys, err := splitTOMLKey(header)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := tomlWalk(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	table := map[string]interface{}{}
+	switch v := parent[last].(type) {
+	case nil:
+		parent[last] = []interface{}{table}
+	case []interface{}:
+		parent[last] = append(v, table)
+	default:
+		return nil, fmt.Errorf("key %q is not an array of tables", last)
+	}
+	return table, nil
+}
+
+// tomlWalk 依序進入keys指定的table，陣列則進入最後一個table
+func tomlWalk(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch v := table[key].(type) {
+		case nil:
+			next := map[string]interface{}{}
+			table[key] = next
+			table = next
+		case map[string]interface{}:
+			table = v
+		case []interface{}:
+			last, ok := v[len(v)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// tomlSet 設定a.b = value，key重複時回傳錯誤
+func tomlSet(table map[string]interface{}, keys []string, v interface{}) error {
+	table, err := tomlWalk(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	last := keys[len(keys)-1]
+	if _, dup := table[last]; dup {
+		return fmt.Errorf("duplicate key %q", last)
+	}
+	table[last] = v
+	return nil
+}
+
+// splitTOMLKey 拆開以"."連接的key
+func splitTOMLKey(s string) ([]string, error) {
+	var keys []string
+	for s = strings.TrimSpace(s); ; {
+		var key string
+		if s != "" && (s[0] == '"' || s[0] == '\'') {
+			end := tomlQuoteEnd(s)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key %s", s)
+			}
+			var err error
+			if key, err = unquoteTOML(s[:end+1]); err != nil {
+				return nil, err
+			}
+			s = strings.TrimSpace(s[end+1:])
+		} else {
+			dot := strings.IndexByte(s, '.')
+			if dot < 0 {
+				dot = len(s)
+			}
+			key = strings.TrimSpace(s[:dot])
+			if !tomlBareKey.MatchString(key) {
+				return nil, fmt.Errorf("invalid key %q", key)
+			}
+			s = s[dot:]
+		}
+		keys = append(keys, key)
+		if s == "" {
+			return keys, nil
+		}
+		if s[0] != '.' {
+			return nil, fmt.Errorf("invalid key %q", s)
+		}
+		s = strings.TrimSpace(s[1:])
+	}
+}
+
+// tomlValue 解析值
+type tomlValue struct {
+	s string
+	i int
+}
+
+func (p *tomlValue) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+func (p *tomlValue) value() (interface{}, error) {
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		return nil, errors.New("missing value")
+	}
+	switch p.s[p.i] {
+	case '"', '\'':
+		end := tomlQuoteEnd(p.s[p.i:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string %s", p.s[p.i:])
+		}
+		s, err := unquoteTOML(p.s[p.i : p.i+end+1])
+		p.i += end + 1
+		return s, err
+	case '[':
+		p.i++
+		list := []interface{}{}
+		for {
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return list, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+				continue
+			}
+			if p.i < len(p.s) && p.s[p.i] == ']' {
+				p.i++
+				return list, nil
+			}
+			return nil, fmt.Errorf("expected \",\" or \"]\" in %s", p.s)
+		}
+	case '{':
+		p.i++
+		table := map[string]interface{}{}
+		for {
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return table, nil
+			}
+			eq := tomlIndex(p.s[p.i:], '=')
+			if eq < 0 {
+				return nil, fmt.Errorf("expected \"key = value\" in %s", p.s)
+			}
+			keys, err := splitTOMLKey(p.s[p.i : p.i+eq])
+			if err != nil {
+				return nil, err
+			}
+			p.i += eq + 1
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			if err := tomlSet(table, keys, v); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.i < len(p.s) && p.s[p.i] == ',' {
+				p.i++
+				continue
+			}
+			if p.i < len(p.s) && p.s[p.i] == '}' {
+				p.i++
+				return table, nil
+			}
+			return nil, fmt.Errorf("expected \",\" or \"}\" in %s", p.s)
+		}
+	}
+
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(",]} \t", rune(p.s[p.i])) {
+		p.i++
+	}
+	return tomlScalar(p.s[start:p.i])
+}
+
+// tomlScalar 解析布林與數字
+func tomlScalar(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	n := strings.Replace(s, "_", "", -1)
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return i, nil
+	}
+	if strings.HasPrefix(n, "0x") || strings.HasPrefix(n, "0o") || strings.HasPrefix(n, "0b") {
+		if i, err := strconv.ParseInt(n, 0, 64); err == nil {
+			return i, nil
+		}
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid value %q, strings must be quoted", s)
+}
+
+// tomlQuoteEnd 字串結尾引號的位置，找不到時為-1
+func tomlQuoteEnd(s string) int {
+	if s[0] == '\'' {
+		if end := strings.IndexByte(s[1:], '\''); end >= 0 {
+			return end + 1
+		}
+		return -1
+	}
+	return quoteEnd(s)
+}
+
+// unquoteTOML 解析字串，'...'不處理跳脫字元
+func unquoteTOML(s string) (string, error) {
+	if s[0] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return v, nil
+}
+
+// tomlIndex 字串外第一個c的位置
+func tomlIndex(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case c:
+			return i
+		case '"', '\'':
+			end := tomlQuoteEnd(s[i:])
+			if end < 0 {
+				return -1
+			}
+			i += end
+		}
+	}
+	return -1
+}
+
+// tomlDepth 字串外尚未結束的括號數
+func tomlDepth(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case '"', '\'':
+			end := tomlQuoteEnd(s[i:])
+			if end < 0 {
+				return depth
+			}
+			i += end
+		}
+	}
+	return depth
+}
+
+// stripTOMLComment 去除字串外的註解
+func stripTOMLComment(line string) string {
+	if i := tomlIndex(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
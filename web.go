@@ -20,11 +20,11 @@ func (proxy *Proxy) WebUI(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("service")
 
 	// 寫網頁
-	if s, ok := proxy.Services[name]; ok {
+	if s, ok := proxy.Services.Get(name); ok {
 		body = head() + service(s) + footer()
 		// body = head() + navbar(r.URL.EscapedPath()) + service(s) + footer()
 	} else {
-		body = head() + services(proxy.Services) + footer()
+		body = head() + services(proxy.Services.table()) + footer()
 		// body = head() + navbar(r.URL.EscapedPath()) + services(proxy.Services) + footer()
 	}
 
@@ -175,7 +175,13 @@ func service(service Service) (html string) {
 	<h2>%s - 方法清單 <a href="/ui" title="服務清單">[back]</a></h2>
 	`, service.Name)
 
-	srvs, err := getService(service.HTTPAddress)
+	endpoint := service.httpEndpoint()
+	if endpoint == nil {
+		html += "<h3>No Endpoint With HTTP Address</h3>"
+		return
+	}
+
+	srvs, err := getService(endpoint.HTTPAddress)
 	if err != nil {
 		html += "<h3>Internal Error</h3>"
 		html += "<h4 style='color:red;'>" + err.Error() + "</h4>"
@@ -211,8 +217,9 @@ func service(service Service) (html string) {
 func services(services map[string]Service) (html string) {
 	var (
 		i        = 1
-		rpcAddr  string
-		httpAddr string
+		rpcAddr  []string
+		httpAddr []string
+		state    []string
 	)
 	html = `
 	<h2>服務位址清單</h2>
@@ -222,6 +229,7 @@ func services(services map[string]Service) (html string) {
 			<th>服務</th>
 			<th>TCP 位址</th>
 			<th>HTTP 位址</th>
+			<th>狀態</th>
 		</tr>
 	`
 
@@ -236,15 +244,20 @@ func services(services map[string]Service) (html string) {
 		if !ok {
 			continue
 		}
-		if strings.HasPrefix(service.RPCAddress, ":") {
-			rpcAddr = "0.0.0.0" + service.RPCAddress
-		} else {
-			rpcAddr = service.RPCAddress
-		}
-		if strings.HasPrefix(service.HTTPAddress, ":") {
-			httpAddr = "0.0.0.0" + service.HTTPAddress
-		} else {
-			httpAddr = service.HTTPAddress
+		rpcAddr = []string{}
+		httpAddr = []string{}
+		state = []string{}
+		for _, ep := range service.Endpoints {
+			rpcAddr = append(rpcAddr, displayAddress(ep.RPCAddress))
+			httpAddr = append(httpAddr, displayAddress(ep.HTTPAddress))
+			switch {
+			case !ep.Healthy():
+				state = append(state, "<span style='color:red;'>DOWN</span>")
+			case ep.Breaker.State() != BreakerClosed:
+				state = append(state, "<span style='color:orange;'>"+strings.ToUpper(string(ep.Breaker.State()))+"</span>")
+			default:
+				state = append(state, "<span style='color:green;'>UP</span>")
+			}
 		}
 
 		html += fmt.Sprintf(`
@@ -253,10 +266,19 @@ func services(services map[string]Service) (html string) {
 			<td><a href="/ui?service=%s">%s</a></td>
 			<td>%s</td>
 			<td>%s</td>
+			<td>%s</td>
 		</tr>
-		`, i, name, service.Name, rpcAddr, httpAddr)
+		`, i, name, service.Name, strings.Join(rpcAddr, "<br>"), strings.Join(httpAddr, "<br>"), strings.Join(state, "<br>"))
 		i++
 	}
 	html += "</table>"
 	return
 }
+
+// displayAddress 補上省略的主機位址
+func displayAddress(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "0.0.0.0" + addr
+	}
+	return addr
+}
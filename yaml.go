@@ -0,0 +1,400 @@
+package zrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 設定檔只需要YAML的一部分：以縮排表示的mapping與sequence、[]與{}、純量與註解
+// anchor、tag、多行字串與多份文件不支援，遇到時回傳錯誤
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlLine 去除註解後的一行
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlParser 依縮排解析YAML
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// yamlToJSON 將YAML設定檔轉為JSON
+func yamlToJSON(b []byte) ([]byte, error) {
+	v, err := parseYAML(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// parseYAML 將YAML解析為map、slice與純量
+func parseYAML(b []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(b), "\n") {
+		raw = strings.TrimRight(raw, " \t\r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", i+1)
+		}
+		text = strings.TrimSpace(stripYAMLComment(text))
+		if text == "" {
+			continue
+		}
+		if text == "---" && len(lines) == 0 {
+			continue
+		}
+		if text == "..." {
+			break
+		}
+		if text == "---" {
+			return nil, fmt.Errorf("yaml: line %d: multiple documents are not supported", i+1)
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	p := &yamlParser{lines: lines}
+	v, err := p.block(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	line := p.lines[len(p.lines)-1]
+	if p.pos < len(p.lines) {
+		line = p.lines[p.pos]
+	}
+	return fmt.Errorf("yaml: line %d: %s", line.num, fmt.Sprintf(format, args...))
+}
+
+// block 解析縮排為indent的mapping或sequence
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+// nested 解析比parent縮排更深的區塊，mapping的值也可以是同縮排的sequence
+func (p *yamlParser) nested(parent int, allowItem bool) (interface{}, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > parent || (allowItem && next.indent == parent && isYAMLItem(next.text)) {
+		return p.block(next.indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := map[string]interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		line := p.lines[p.pos]
+		if isYAMLItem(line.text) {
+			break
+		}
+		key, rest, ok, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if !ok {
+			return nil, p.errorf("expected \"key: value\", got %q", line.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+
+		var v interface{}
+		if rest == "" {
+			p.pos++
+			v, err = p.nested(indent, true)
+		} else {
+			v, err = parseYAMLValue(rest)
+			if err != nil {
+				return nil, p.errorf("%s", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		line := p.lines[p.pos]
+		content := strings.TrimLeft(line.text[1:], " ")
+		if content == "" {
+			p.pos++
+			v, err := p.nested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+
+		// 項目內容視為縮排到內容位置的一行，後續同縮排的行屬於同一個項目
+		_, _, isKey, _ := splitYAMLKey(content)
+		if isKey || isYAMLItem(content) {
+			p.lines[p.pos] = yamlLine{num: line.num, indent: indent + len(line.text) - len(content), text: content}
+			v, err := p.block(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		v, err := parseYAMLValue(content)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		p.pos++
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// isYAMLItem 是否為sequence的項目
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey 拆出"key: value"的key與value，不是mapping的項目時ok為false
+func splitYAMLKey(text string) (key, rest string, ok bool, err error) {
+	switch text[0] {
+	case '"', '\'':
+		end := quoteEnd(text)
+		if end < 0 {
+			return "", "", false, nil
+		}
+		after := strings.TrimLeft(text[end+1:], " ")
+		if after != ":" && !strings.HasPrefix(after, ": ") {
+			return "", "", false, nil
+		}
+		key, err = unquoteYAML(text[:end+1])
+		return key, strings.TrimSpace(after[1:]), err == nil, err
+	case '[', '{':
+		return "", "", false, nil
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		if !strings.HasSuffix(text, ":") {
+			return "", "", false, nil
+		}
+		i = len(text) - 1
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true, nil
+}
+
+// parseYAMLValue 解析同一行的值
+func parseYAMLValue(s string) (interface{}, error) {
+	switch s[0] {
+	case '[', '{':
+		f := &yamlFlow{s: s}
+		v, err := f.value(false)
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.i < len(s) {
+			return nil, fmt.Errorf("unexpected %q after %q", s[f.i:], s[:f.i])
+		}
+		return v, nil
+	case '"', '\'':
+		if quoteEnd(s) != len(s)-1 {
+			return nil, fmt.Errorf("invalid quoted string %s", s)
+		}
+		return unquoteYAML(s)
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, fmt.Errorf("%q is not supported in configuration files", s[:1])
+	}
+	return yamlScalar(s), nil
+}
+
+// yamlScalar 解析未加引號的純量
+func yamlScalar(s string) interface{} {
+	switch s {
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case "null", "Null", "NULL", "~":
+		return nil
+	}
+	if yamlInt.MatchString(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// yamlFlow 解析[]與{}
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// value 讀取一個值，key為true時讀到":"為止
+func (f *yamlFlow) value(key bool) (interface{}, error) {
+	f.skipSpace()
+	if f.i >= len(f.s) {
+		return nil, errors.New("unexpected end of flow collection")
+	}
+	switch f.s[f.i] {
+	case '[':
+		f.i++
+		list := []interface{}{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == ']' {
+				f.i++
+				return list, nil
+			}
+			v, err := f.value(false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.next(']'); err != nil {
+				return nil, err
+			}
+			if f.s[f.i-1] == ']' {
+				return list, nil
+			}
+		}
+	case '{':
+		f.i++
+		m := map[string]interface{}{}
+		for {
+			f.skipSpace()
+			if f.i < len(f.s) && f.s[f.i] == '}' {
+				f.i++
+				return m, nil
+			}
+			k, err := f.value(true)
+			if err != nil {
+				return nil, err
+			}
+			f.skipSpace()
+			if f.i >= len(f.s) || f.s[f.i] != ':' {
+				return nil, fmt.Errorf("expected \":\" after key %v", k)
+			}
+			f.i++
+			v, err := f.value(false)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+			if err := f.next('}'); err != nil {
+				return nil, err
+			}
+			if f.s[f.i-1] == '}' {
+				return m, nil
+			}
+		}
+	case '"', '\'':
+		end := quoteEnd(f.s[f.i:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string %s", f.s[f.i:])
+		}
+		s, err := unquoteYAML(f.s[f.i : f.i+end+1])
+		f.i += end + 1
+		return s, err
+	}
+
+	start := f.i
+	for f.i < len(f.s) && !strings.ContainsRune(",]}", rune(f.s[f.i])) && !(key && f.s[f.i] == ':') {
+		f.i++
+	}
+	return yamlScalar(strings.TrimSpace(f.s[start:f.i])), nil
+}
+
+// next 讀取項目之間的","或結尾的end
+func (f *yamlFlow) next(end byte) error {
+	f.skipSpace()
+	if f.i < len(f.s) && (f.s[f.i] == ',' || f.s[f.i] == end) {
+		f.i++
+		return nil
+	}
+	return fmt.Errorf("expected \",\" or %q in %s", end, f.s)
+}
+
+// stripYAMLComment 去除引號外的註解
+func stripYAMLComment(text string) string {
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		case (c == '"' || c == '\'') && (i == 0 || strings.ContainsRune(" :-[{,", rune(text[i-1]))):
+			if end := quoteEnd(text[i:]); end > 0 {
+				i += end
+			}
+		}
+	}
+	return text
+}
+
+// quoteEnd 引號字串結尾引號的位置，找不到時為-1
+func quoteEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// unquoteYAML 解析加上引號的字串
+func unquoteYAML(s string) (string, error) {
+	if s[0] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted string %s", s)
+	}
+	return v, nil
+}